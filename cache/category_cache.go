@@ -2,67 +2,152 @@ package cache
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// CategoryCache 分类缓存接口
+// CategoryCache 分类缓存接口（只保存学习到的分类，逐条写入，按 category_cache.ttl 过期）
+// 预加载分类由 category.DomainSet 在进程内整代替换，不写入分类缓存
 type CategoryCache interface {
 	Get(domain string) (string, error)
-	Set(domain, category string) error
-	BatchSet(data map[string]string) error
+	// GetLongestSuffix 一次查询域名自身及所有父域名，返回最具体的匹配（域名, 分类），未匹配时返回空字符串
+	GetLongestSuffix(domain string) (string, string, error)
+	// Set 写入学习到的分类及其依据（evidence 可为 nil）
+	Set(domain, category string, evidence *Evidence) error
+	// GetEvidence 获取学习依据（没有记录时返回 nil）
+	GetEvidence(domain string) (*Evidence, error)
+	// Range 遍历全部未过期的学习分类，fn 返回 false 时停止
+	Range(fn func(domain, category string, evidence *Evidence) bool) error
 	Delete(domain string) error
 	Clear() error
 }
 
 // MemoryCategoryCache 内存分类缓存
 type MemoryCategoryCache struct {
-	data map[string]string
-	mu   sync.RWMutex
+	data    map[string]categoryEntry
+	ttl     time.Duration // 学习到的分类的有效期（0 表示永不过期）
+	sweepAt time.Time     // 下次清理过期条目的时间（受 mu 保护）
+	mu      sync.RWMutex
+}
+
+// categoryEntry 内存分类缓存条目
+type categoryEntry struct {
+	category string
+	evidence *Evidence
+	expireAt time.Time // 零值表示永不过期
+}
+
+// expired 检查条目是否过期
+func (e categoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && now.After(e.expireAt)
 }
 
 // NewMemoryCategoryCache 创建内存分类缓存
-func NewMemoryCategoryCache() *MemoryCategoryCache {
+func NewMemoryCategoryCache(ttl time.Duration) *MemoryCategoryCache {
 	return &MemoryCategoryCache{
-		data: make(map[string]string),
+		data: make(map[string]categoryEntry),
+		ttl:  ttl,
 	}
 }
 
-// Get 获取域名分类
+// Get 获取域名分类（过期条目视为不存在并清理）
 func (c *MemoryCategoryCache) Get(domain string) (string, error) {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	entry, exists := c.data[domain]
+	c.mu.RUnlock()
 
-	category, exists := c.data[domain]
 	if !exists {
 		return "", nil
 	}
 
-	return category, nil
+	if entry.expired(time.Now()) {
+		c.mu.Lock()
+		if current, ok := c.data[domain]; ok && current.expired(time.Now()) {
+			delete(c.data, domain)
+		}
+		c.mu.Unlock()
+		return "", nil
+	}
+
+	return entry.category, nil
 }
 
-// Set 设置域名分类
-func (c *MemoryCategoryCache) Set(domain, category string) error {
+// GetLongestSuffix 获取最具体的后缀匹配（一次加锁，跳过过期条目）
+func (c *MemoryCategoryCache) GetLongestSuffix(domain string) (string, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	for _, suffix := range domainSuffixes(domain) {
+		if entry, exists := c.data[suffix]; exists && entry.category != "" && !entry.expired(now) {
+			return suffix, entry.category, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// Set 设置域名分类（同时按间隔清理过期条目）
+func (c *MemoryCategoryCache) Set(domain, category string, evidence *Evidence) error {
+	now := time.Now()
+	entry := categoryEntry{category: category, evidence: evidence}
+	if c.ttl > 0 {
+		entry.expireAt = now.Add(c.ttl)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data[domain] = category
+	c.data[domain] = entry
+	c.sweepLocked(now)
 	return nil
 }
 
-// BatchSet 批量设置域名分类
-func (c *MemoryCategoryCache) BatchSet(data map[string]string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// sweepLocked 距上次清理超过 memorySweepInterval 时删除全部过期条目（调用方持有写锁）
+// GetLongestSuffix 和 Range 只跳过过期条目，不再查询的域名只能在这里清理
+func (c *MemoryCategoryCache) sweepLocked(now time.Time) {
+	if c.ttl <= 0 || now.Before(c.sweepAt) {
+		return
+	}
+	c.sweepAt = now.Add(memorySweepInterval)
 
-	for domain, category := range data {
-		c.data[domain] = category
+	for domain, entry := range c.data {
+		if entry.expired(now) {
+			delete(c.data, domain)
+		}
 	}
+}
 
+// GetEvidence 获取学习依据
+func (c *MemoryCategoryCache) GetEvidence(domain string) (*Evidence, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.data[domain]
+	if !exists || entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return entry.evidence, nil
+}
+
+// Range 遍历全部未过期的学习分类
+func (c *MemoryCategoryCache) Range(fn func(domain, category string, evidence *Evidence) bool) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	for domain, entry := range c.data {
+		if entry.expired(now) {
+			continue
+		}
+		if !fn(domain, entry.category, entry.evidence) {
+			break
+		}
+	}
 	return nil
 }
 
@@ -80,136 +165,152 @@ func (c *MemoryCategoryCache) Clear() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data = make(map[string]string)
+	c.data = make(map[string]categoryEntry)
 	return nil
 }
 
-// RedisCategoryCache Redis 分类缓存
+// learnedKeyPrefix 学习到的分类在 Redis 中的键前缀
+// 与旧版本 -load 写入的永久预加载分类（category:<域名>）分开存放，后者不再参与匹配
+const learnedKeyPrefix = "category:learned:"
+
+// evidenceKeyPrefix 学习依据在 Redis 中的键前缀（JSON，与分类同时写入、同时过期）
+const evidenceKeyPrefix = "category:evidence:"
+
+// legacyCleanedKey 旧版本预加载分类已清理的标记（存在时 CleanupLegacy 不再扫描）
+const legacyCleanedKey = "category:legacy_cleaned"
+
+// RedisCategoryCache Redis 分类缓存（只保存逐条写入、逐条过期的学习分类）
 type RedisCategoryCache struct {
 	client *redis.Client
+	ttl    time.Duration // 学习到的分类的有效期（0 表示永不过期）
 }
 
 // NewRedisCategoryCache 创建 Redis 分类缓存
-func NewRedisCategoryCache(client *redis.Client) *RedisCategoryCache {
+func NewRedisCategoryCache(client *redis.Client, ttl time.Duration) *RedisCategoryCache {
 	return &RedisCategoryCache{
 		client: client,
+		ttl:    ttl,
 	}
 }
 
 // Get 获取域名分类
 func (c *RedisCategoryCache) Get(domain string) (string, error) {
 	ctx := context.Background()
-	return c.client.Get(ctx, "category:"+domain).Result()
-}
-
-// Set 设置域名分类
-func (c *RedisCategoryCache) Set(domain, category string) error {
-	ctx := context.Background()
-	return c.client.Set(ctx, "category:"+domain, category, 0).Err()
+	return c.client.Get(ctx, learnedKeyPrefix+domain).Result()
 }
 
-// BatchSet 批量设置域名分类
-func (c *RedisCategoryCache) BatchSet(data map[string]string) error {
+// GetLongestSuffix 获取最具体的后缀匹配（一次 MGET）
+func (c *RedisCategoryCache) GetLongestSuffix(domain string) (string, string, error) {
 	ctx := context.Background()
 
-	// 分批写入，每批最多 100 条（减小批次以避免 broken pipe）
-	const batchSize = 100
-	batch := make(map[string]string, batchSize)
-	count := 0
-	totalWritten := 0
-	totalItems := len(data)
+	suffixes := domainSuffixes(domain)
+	if len(suffixes) == 0 {
+		return "", "", nil
+	}
 
-	log.Printf("开始批量写入 %d 条域名分类到 Redis (每批 %d 条)...\n", totalItems, batchSize)
+	keys := make([]string, len(suffixes))
+	for i, suffix := range suffixes {
+		keys[i] = learnedKeyPrefix + suffix
+	}
 
-	for domain, category := range data {
-		batch[domain] = category
-		count++
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return "", "", err
+	}
 
-		// 当批次达到大小时执行写入
-		if count >= batchSize {
-			if err := c.executeBatchWithRetry(ctx, batch, 3); err != nil {
-				return fmt.Errorf("批量写入失败 (已写入 %d/%d 条): %w", totalWritten, totalItems, err)
-			}
-			totalWritten += len(batch)
+	for i, value := range values {
+		if category, ok := value.(string); ok && category != "" {
+			return suffixes[i], category, nil
+		}
+	}
 
-			// 每 10 批显示一次进度
-			if totalWritten%1000 == 0 || totalWritten == totalItems {
-				log.Printf("进度: %d/%d (%.1f%%)\n", totalWritten, totalItems, float64(totalWritten)/float64(totalItems)*100)
-			}
+	return "", "", nil
+}
 
-			// 重置批次
-			batch = make(map[string]string, batchSize)
-			count = 0
+// Set 设置域名分类（分类和依据在同一事务中写入）
+func (c *RedisCategoryCache) Set(domain, category string, evidence *Evidence) error {
+	ctx := context.Background()
 
-			// 添加小延迟避免过快发送导致连接问题
-			time.Sleep(10 * time.Millisecond)
+	var data []byte
+	if evidence != nil {
+		var err error
+		if data, err = json.Marshal(evidence); err != nil {
+			return err
 		}
 	}
 
-	// 写入剩余的数据
-	if len(batch) > 0 {
-		if err := c.executeBatchWithRetry(ctx, batch, 3); err != nil {
-			return fmt.Errorf("批量写入失败 (最后一批): %w", err)
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, learnedKeyPrefix+domain, category, c.ttl)
+		if data != nil {
+			pipe.Set(ctx, evidenceKeyPrefix+domain, data, c.ttl)
+		} else {
+			pipe.Del(ctx, evidenceKeyPrefix+domain)
 		}
-		totalWritten += len(batch)
-		log.Printf("进度: %d/%d (100.0%%)\n", totalWritten, totalItems)
+		return nil
+	})
+	return err
+}
+
+// GetEvidence 获取学习依据
+func (c *RedisCategoryCache) GetEvidence(domain string) (*Evidence, error) {
+	ctx := context.Background()
+
+	data, err := c.client.Get(ctx, evidenceKeyPrefix+domain).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("批量写入完成，共写入 %d 条域名分类\n", totalWritten)
-	return nil
+	var evidence Evidence
+	if err := json.Unmarshal(data, &evidence); err != nil {
+		return nil, err
+	}
+	return &evidence, nil
 }
 
-// executeBatchWithRetry 执行单批次写入（带重试）
-func (c *RedisCategoryCache) executeBatchWithRetry(ctx context.Context, batch map[string]string, maxRetries int) error {
-	var lastErr error
-
-	for i := 0; i < maxRetries; i++ {
-		if err := c.executeBatch(ctx, batch); err != nil {
-			lastErr = err
-			// 如果失败，等待后重试
-			if i < maxRetries-1 {
-				waitTime := time.Duration(i+1) * 100 * time.Millisecond
-				log.Printf("批次写入失败 (第 %d/%d 次尝试): %v，%v 后重试...\n", i+1, maxRetries, err, waitTime)
-				time.Sleep(waitTime)
-
-				// 测试连接是否还活着
-				if err := c.client.Ping(ctx).Err(); err != nil {
-					log.Printf("Redis 连接已断开: %v\n", err)
-					return fmt.Errorf("Redis 连接断开: %w", err)
-				}
-			}
-			continue
+// Range 遍历全部学习分类（SCAN，用于离线审计，不在查询路径上使用）
+func (c *RedisCategoryCache) Range(fn func(domain, category string, evidence *Evidence) bool) error {
+	ctx := context.Background()
+
+	iter := c.client.Scan(ctx, 0, learnedKeyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		domain := strings.TrimPrefix(iter.Val(), learnedKeyPrefix)
+
+		category, err := c.client.Get(ctx, iter.Val()).Result()
+		if err == redis.Nil {
+			continue // 遍历期间过期
+		}
+		if err != nil {
+			return err
 		}
-		// 成功
-		return nil
-	}
 
-	return fmt.Errorf("重试 %d 次后仍然失败: %w", maxRetries, lastErr)
-}
+		evidence, err := c.GetEvidence(domain)
+		if err != nil {
+			return err
+		}
 
-// executeBatch 执行单批次写入
-func (c *RedisCategoryCache) executeBatch(ctx context.Context, batch map[string]string) error {
-	// 使用 MSET 命令而不是 Pipeline，更稳定
-	// MSET 是原子操作，一次设置多个键值对
-	args := make([]interface{}, 0, len(batch)*2)
-	for domain, category := range batch {
-		args = append(args, "category:"+domain, category)
+		if !fn(domain, category, evidence) {
+			return nil
+		}
 	}
 
-	return c.client.MSet(ctx, args...).Err()
+	return iter.Err()
 }
 
 // Delete 删除域名分类
 func (c *RedisCategoryCache) Delete(domain string) error {
 	ctx := context.Background()
-	return c.client.Del(ctx, "category:"+domain).Err()
+	return c.client.Del(ctx, learnedKeyPrefix+domain, evidenceKeyPrefix+domain).Err()
 }
 
 // Clear 清空缓存
 func (c *RedisCategoryCache) Clear() error {
 	ctx := context.Background()
 
-	// 删除所有 category: 前缀的键
+	// 删除所有 category: 前缀的键：学习到的分类、学习依据、旧版本写入的预加载分类和清理标记
+	// 只清除旧版本预加载分类时使用 CleanupLegacy
 	iter := c.client.Scan(ctx, 0, "category:*", 0).Iterator()
 	for iter.Next(ctx) {
 		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
@@ -219,3 +320,69 @@ func (c *RedisCategoryCache) Clear() error {
 
 	return iter.Err()
 }
+
+// CleanupLegacy 一次性删除旧版本 -load 写入的永久预加载分类（category:<域名>），
+// 保留学习到的分类和学习依据；完成后写入标记，之后的调用直接返回。返回删除的键数量
+func (c *RedisCategoryCache) CleanupLegacy() (int, error) {
+	ctx := context.Background()
+
+	done, err := c.client.Exists(ctx, legacyCleanedKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if done > 0 {
+		return 0, nil
+	}
+
+	deleted := 0
+	batch := make([]string, 0, 1000)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.client.Unlink(ctx, batch...).Err(); err != nil {
+			return err
+		}
+		deleted += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := c.client.Scan(ctx, 0, "category:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if strings.HasPrefix(key, learnedKeyPrefix) || strings.HasPrefix(key, evidenceKeyPrefix) || key == legacyCleanedKey {
+			continue
+		}
+		batch = append(batch, key)
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	if err := flush(); err != nil {
+		return deleted, err
+	}
+
+	return deleted, c.client.Set(ctx, legacyCleanedKey, time.Now().Unix(), 0).Err()
+}
+
+// domainSuffixes 返回域名自身及所有父域名，最具体的在前
+// 例如: www.google.com -> [www.google.com, google.com, com]
+func domainSuffixes(domain string) []string {
+	if domain == "" {
+		return nil
+	}
+
+	suffixes := []string{domain}
+	for i := 0; i < len(domain); i++ {
+		if domain[i] == '.' && i+1 < len(domain) {
+			suffixes = append(suffixes, domain[i+1:])
+		}
+	}
+	return suffixes
+}
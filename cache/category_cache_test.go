@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCategoryCacheExpiry(t *testing.T) {
+	c := NewMemoryCategoryCache(time.Millisecond)
+	for _, domain := range []string{"a.example.com", "b.example.com", "example.org"} {
+		if err := c.Set(domain, "proxy_site", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// 读取时跳过过期条目
+	if suffix, category, _ := c.GetLongestSuffix("x.a.example.com"); suffix != "" || category != "" {
+		t.Errorf("GetLongestSuffix = %s, %s, 期望过期条目不匹配", suffix, category)
+	}
+	c.Range(func(domain, category string, evidence *Evidence) bool {
+		t.Errorf("Range 返回过期条目 %s", domain)
+		return true
+	})
+
+	// 写入时清理全部过期条目，不依赖再次 Get
+	c.mu.Lock()
+	c.sweepAt = time.Time{}
+	c.mu.Unlock()
+	c.ttl = time.Hour
+	if err := c.Set("new.example.com", "direct_site", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.data) != 1 {
+		t.Errorf("条目数 = %d, 期望只剩新写入的 1 条", len(c.data))
+	}
+	if _, ok := c.data["new.example.com"]; !ok {
+		t.Error("新写入的条目不应被清理")
+	}
+}
+
+func TestMemoryCategoryCacheSweepInterval(t *testing.T) {
+	c := NewMemoryCategoryCache(time.Millisecond)
+	c.Set("a.example.com", "proxy_site", nil)
+	time.Sleep(5 * time.Millisecond)
+
+	// 清理间隔内的写入不再遍历全部条目
+	c.Set("b.example.com", "proxy_site", nil)
+	c.mu.RLock()
+	n := len(c.data)
+	c.mu.RUnlock()
+	if n != 2 {
+		t.Errorf("条目数 = %d, 期望清理间隔内保留 2 条", n)
+	}
+}
@@ -37,13 +37,17 @@ func (item *RRCacheItem) RemainingTTL(now time.Time) int {
 
 // CacheKey RR 缓存键
 type CacheKey struct {
-	Name  string // 规范化的域名（小写，带尾点）
-	Type  uint16 // 记录类型（A, AAAA, CNAME 等）
-	Class uint16 // 记录类别（通常是 IN）
+	Name   string // 规范化的域名（小写，带尾点）
+	Type   uint16 // 记录类型（A, AAAA, CNAME 等）
+	Class  uint16 // 记录类别（通常是 IN）
+	Subnet string // ECS 子网（client 模式下按客户端子网隔离，空表示不区分）
 }
 
 // String 生成缓存键字符串
 func (k CacheKey) String() string {
+	if k.Subnet != "" {
+		return fmt.Sprintf("%s:%d:%d@%s", k.Name, k.Type, k.Class, k.Subnet)
+	}
 	return fmt.Sprintf("%s:%d:%d", k.Name, k.Type, k.Class)
 }
 
@@ -61,32 +65,46 @@ type DNSCache interface {
 	// DeleteRRs 删除指定 qname 和 qtype 的所有 RR 记录
 	DeleteRRs(qname string, qtype uint16) error
 
+	// SetNoData 缓存 NODATA 结果（域名存在但没有该类型的记录）
+	SetNoData(qname string, qtype uint16, ttl uint32) error
+
+	// IsNoData 检查是否缓存了未过期的 NODATA 结果
+	IsNoData(qname string, qtype uint16) bool
+
+	// WithSubnet 返回按 ECS 子网隔离的缓存视图（与原缓存共享存储，subnet 为空时返回自身）
+	WithSubnet(subnet string) DNSCache
+
 	// Clear 清空所有缓存
 	Clear() error
 }
 
 // MemoryDNSCache 内存 DNS 缓存（RR 级别）
 type MemoryDNSCache struct {
-	mu      sync.RWMutex
+	mu      *sync.RWMutex
 	storage map[string][]*RRCacheItem // key -> RR 列表
+	noData  map[string]time.Time      // key -> NODATA 过期时间
 	maxTTL  time.Duration             // 最大允许 TTL
+	subnet  string                    // ECS 子网（见 WithSubnet）
+	sweepAt *time.Time                // 下次清理过期条目的时间（各子网视图共享，受 mu 保护）
 }
 
+// memorySweepInterval 写入时清理过期条目的最小间隔（只在读取时清理会让不再查询的条目一直留在内存中）
+const memorySweepInterval = time.Minute
+
 // NewMemoryDNSCache 创建新的内存 DNS 缓存
 func NewMemoryDNSCache(maxTTL time.Duration) *MemoryDNSCache {
 	return &MemoryDNSCache{
+		mu:      new(sync.RWMutex),
 		storage: make(map[string][]*RRCacheItem),
+		noData:  make(map[string]time.Time),
 		maxTTL:  maxTTL,
+		sweepAt: new(time.Time),
 	}
 }
 
 // GetRRs 获取 RR 记录（自动过滤过期记录）
 func (c *MemoryDNSCache) GetRRs(qname string, qtype uint16) ([]*RRCacheItem, bool) {
-	key := CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := c.key(qname, qtype)
 
 	c.mu.RLock()
 	items, exists := c.storage[key]
@@ -129,11 +147,7 @@ func (c *MemoryDNSCache) SetRRs(qname string, qtype uint16, items []*RRCacheItem
 		return nil
 	}
 
-	key := CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := c.key(qname, qtype)
 
 	// 限制最大 TTL
 	now := time.Now().UTC()
@@ -146,6 +160,7 @@ func (c *MemoryDNSCache) SetRRs(qname string, qtype uint16, items []*RRCacheItem
 
 	c.mu.Lock()
 	c.storage[key] = items
+	c.sweepLocked(now)
 	c.mu.Unlock()
 
 	return nil
@@ -155,9 +170,10 @@ func (c *MemoryDNSCache) SetRRs(qname string, qtype uint16, items []*RRCacheItem
 func (c *MemoryDNSCache) SetSingleRR(item *RRCacheItem) error {
 	hdr := item.RR.Header()
 	key := CacheKey{
-		Name:  hdr.Name,
-		Type:  hdr.Rrtype,
-		Class: hdr.Class,
+		Name:   hdr.Name,
+		Type:   hdr.Rrtype,
+		Class:  hdr.Class,
+		Subnet: c.subnet,
 	}.String()
 
 	// 限制最大 TTL
@@ -175,11 +191,7 @@ func (c *MemoryDNSCache) SetSingleRR(item *RRCacheItem) error {
 
 // DeleteRRs 删除指定 qname 和 qtype 的所有 RR 记录
 func (c *MemoryDNSCache) DeleteRRs(qname string, qtype uint16) error {
-	key := CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := c.key(qname, qtype)
 
 	c.mu.Lock()
 	delete(c.storage, key)
@@ -188,14 +200,109 @@ func (c *MemoryDNSCache) DeleteRRs(qname string, qtype uint16) error {
 	return nil
 }
 
-// Clear 清空所有缓存
+// SetNoData 缓存 NODATA 结果（ttl 为 0 时不缓存）
+func (c *MemoryDNSCache) SetNoData(qname string, qtype uint16, ttl uint32) error {
+	if ttl == 0 {
+		return nil
+	}
+	key := c.key(qname, qtype)
+
+	duration := time.Duration(ttl) * time.Second
+	if duration > c.maxTTL {
+		duration = c.maxTTL
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	c.noData[key] = now.Add(duration)
+	c.sweepLocked(now)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// IsNoData 检查是否缓存了未过期的 NODATA 结果
+func (c *MemoryDNSCache) IsNoData(qname string, qtype uint16) bool {
+	key := c.key(qname, qtype)
+
+	c.mu.RLock()
+	expireAt, exists := c.noData[key]
+	c.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	if time.Now().After(expireAt) {
+		c.mu.Lock()
+		delete(c.noData, key)
+		c.mu.Unlock()
+		return false
+	}
+
+	return true
+}
+
+// sweepLocked 距上次清理超过 memorySweepInterval 时删除全部过期的记录和 NODATA 条目（调用方持有写锁）
+func (c *MemoryDNSCache) sweepLocked(now time.Time) {
+	if now.Before(*c.sweepAt) {
+		return
+	}
+	*c.sweepAt = now.Add(memorySweepInterval)
+
+	for key, expireAt := range c.noData {
+		if now.After(expireAt) {
+			delete(c.noData, key)
+		}
+	}
+	for key, items := range c.storage {
+		expired := true
+		for _, item := range items {
+			if !item.IsExpired(now.UTC()) {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			delete(c.storage, key)
+		}
+	}
+}
+
+// WithSubnet 返回按 ECS 子网隔离的缓存视图
+func (c *MemoryDNSCache) WithSubnet(subnet string) DNSCache {
+	if subnet == "" || subnet == c.subnet {
+		return c
+	}
+	return &MemoryDNSCache{
+		mu:      c.mu,
+		storage: c.storage,
+		noData:  c.noData,
+		maxTTL:  c.maxTTL,
+		subnet:  subnet,
+		sweepAt: c.sweepAt,
+	}
+}
+
+// Clear 清空所有缓存（包括所有子网视图）
 func (c *MemoryDNSCache) Clear() error {
 	c.mu.Lock()
-	c.storage = make(map[string][]*RRCacheItem)
+	clear(c.storage)
+	clear(c.noData)
 	c.mu.Unlock()
 	return nil
 }
 
+// key 生成缓存键字符串
+func (c *MemoryDNSCache) key(qname string, qtype uint16) string {
+	return CacheKey{
+		Name:   dns.Fqdn(qname),
+		Type:   qtype,
+		Class:  dns.ClassINET,
+		Subnet: c.subnet,
+	}.String()
+}
+
 // ParseResponseToRRCache 将 DNS 响应解析为 RR 缓存项
 func ParseResponseToRRCache(msg *dns.Msg) []*RRCacheItem {
 	items := make([]*RRCacheItem, 0, len(msg.Answer))
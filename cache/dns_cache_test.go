@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMemoryDNSCacheNoDataZeroTTL(t *testing.T) {
+	c := NewMemoryDNSCache(time.Hour)
+
+	// TTL 为 0 的 NODATA 不缓存，否则该协议族会一直被当作没有记录
+	c.SetNoData("example.com", dns.TypeAAAA, 0)
+	if c.IsNoData("example.com", dns.TypeAAAA) {
+		t.Fatal("TTL 为 0 的 NODATA 不应被缓存")
+	}
+
+	c.SetNoData("example.com", dns.TypeAAAA, 60)
+	if !c.IsNoData("example.com", dns.TypeAAAA) {
+		t.Fatal("NODATA 应被缓存")
+	}
+}
+
+func TestMemoryDNSCacheSweepsExpiredEntries(t *testing.T) {
+	c := NewMemoryDNSCache(time.Hour)
+	scoped := c.WithSubnet("192.0.2.0/24").(*MemoryDNSCache)
+
+	past := time.Now().Add(-time.Minute)
+	c.mu.Lock()
+	for _, name := range []string{"a.example.", "b.example."} {
+		c.noData[c.key(name, dns.TypeAAAA)] = past
+		scoped.noData[scoped.key(name, dns.TypeAAAA)] = past
+		c.storage[c.key(name, dns.TypeA)] = []*RRCacheItem{{OrigTTL: 1, StoredAt: past}}
+	}
+	c.mu.Unlock()
+
+	// 写入时清理全部子网视图中的过期条目，不依赖再次读取
+	if err := c.SetNoData("c.example", dns.TypeAAAA, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.noData) != 1 {
+		t.Errorf("NODATA 条目 = %d, 期望只剩新写入的 1 条", len(c.noData))
+	}
+	if len(c.storage) != 0 {
+		t.Errorf("记录条目 = %d, 期望过期记录被清理", len(c.storage))
+	}
+}
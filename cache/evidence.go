@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Evidence 学习到某个分类的依据（用于审计和批量撤销错误的分类）
+type Evidence struct {
+	IPs       []string  `json:"ips"`                  // 被判断的结果 IP
+	Rule      string    `json:"rule,omitempty"`       // 匹配的 race 组规则（未匹配时为空）
+	Group     string    `json:"group"`                // 被判断结果来自的上游组
+	RaceGroup string    `json:"race_group,omitempty"` // 做出判断的 race 组
+	Time      time.Time `json:"time"`                 // 学习时间
+}
+
+// EvidenceFilter 学习分类的筛选条件（各条件之间为 AND，空条件不限制）
+type EvidenceFilter struct {
+	Category  string
+	Group     string
+	RaceGroup string
+	Rule      string
+	IP        *net.IPNet
+	Since     time.Time
+}
+
+// ParseEvidenceFilter 解析筛选条件
+// 格式: "key=value,key=value"，支持的 key:
+//   - category: 学习到的分类
+//   - group: 被判断结果来自的上游组
+//   - race_group: 做出判断的 race 组
+//   - rule: 匹配的规则（如 geoip:cn），"none" 表示未匹配任何规则
+//   - ip: 结果中包含该 IP 或 CIDR 内的 IP
+//   - since: 学习时间不早于该时间（RFC3339 或相对时长，如 24h）
+func ParseEvidenceFilter(s string) (*EvidenceFilter, error) {
+	filter := &EvidenceFilter{}
+	if strings.TrimSpace(s) == "" || s == "all" {
+		return filter, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("无效的筛选条件: %s", part)
+		}
+
+		switch key {
+		case "category":
+			filter.Category = value
+		case "group":
+			filter.Group = value
+		case "race_group":
+			filter.RaceGroup = value
+		case "rule":
+			filter.Rule = value
+		case "ip":
+			if !strings.Contains(value, "/") {
+				if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+					value += "/32"
+				} else {
+					value += "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 IP: %s", value)
+			}
+			filter.IP = ipNet
+		case "since":
+			if d, err := time.ParseDuration(value); err == nil {
+				filter.Since = time.Now().Add(-d)
+			} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+				filter.Since = t
+			} else {
+				return nil, fmt.Errorf("无效的时间: %s", value)
+			}
+		default:
+			return nil, fmt.Errorf("未知的筛选条件: %s", key)
+		}
+	}
+
+	return filter, nil
+}
+
+// Match 检查学习到的分类是否符合筛选条件（没有依据的条目只能按分类筛选）
+func (f *EvidenceFilter) Match(category string, evidence *Evidence) bool {
+	if f.Category != "" && f.Category != category {
+		return false
+	}
+
+	if f.Group == "" && f.RaceGroup == "" && f.Rule == "" && f.IP == nil && f.Since.IsZero() {
+		return true
+	}
+	if evidence == nil {
+		return false
+	}
+
+	if f.Group != "" && f.Group != evidence.Group {
+		return false
+	}
+	if f.RaceGroup != "" && f.RaceGroup != evidence.RaceGroup {
+		return false
+	}
+	if f.Rule != "" {
+		if f.Rule == "none" {
+			if evidence.Rule != "" {
+				return false
+			}
+		} else if f.Rule != evidence.Rule {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && evidence.Time.Before(f.Since) {
+		return false
+	}
+	if f.IP != nil {
+		found := false
+		for _, s := range evidence.IPs {
+			if ip := net.ParseIP(s); ip != nil && f.IP.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
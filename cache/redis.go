@@ -14,6 +14,7 @@ import (
 type RedisDNSCache struct {
 	client *redis.Client
 	maxTTL time.Duration
+	subnet string // ECS 子网（见 WithSubnet）
 }
 
 // NewRedisDNSCache 创建新的 Redis DNS 缓存
@@ -27,11 +28,7 @@ func NewRedisDNSCache(client *redis.Client, maxTTL time.Duration) *RedisDNSCache
 // GetRRs 获取 RR 记录
 func (c *RedisDNSCache) GetRRs(qname string, qtype uint16) ([]*RRCacheItem, bool) {
 	ctx := context.Background()
-	key := "dns:" + CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := "dns:" + c.key(qname, qtype)
 
 	// 获取所有成员（RR 记录）
 	members, err := c.client.ZRangeWithScores(ctx, key, 0, -1).Result()
@@ -81,11 +78,7 @@ func (c *RedisDNSCache) SetRRs(qname string, qtype uint16, items []*RRCacheItem)
 	}
 
 	ctx := context.Background()
-	key := "dns:" + CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := "dns:" + c.key(qname, qtype)
 
 	now := time.Now().UTC()
 
@@ -142,16 +135,59 @@ func (c *RedisDNSCache) SetSingleRR(item *RRCacheItem) error {
 // DeleteRRs 删除指定 qname 和 qtype 的所有 RR 记录
 func (c *RedisDNSCache) DeleteRRs(qname string, qtype uint16) error {
 	ctx := context.Background()
-	key := "dns:" + CacheKey{
-		Name:  dns.Fqdn(qname),
-		Type:  qtype,
-		Class: dns.ClassINET,
-	}.String()
+	key := "dns:" + c.key(qname, qtype)
 
 	return c.client.Del(ctx, key).Err()
 }
 
-// Clear 清空所有 DNS 缓存
+// SetNoData 缓存 NODATA 结果（ttl 为 0 时不缓存：Redis 中 0 表示永不过期）
+func (c *RedisDNSCache) SetNoData(qname string, qtype uint16, ttl uint32) error {
+	if ttl == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	key := "dns:nodata:" + c.key(qname, qtype)
+
+	duration := time.Duration(ttl) * time.Second
+	if duration > c.maxTTL {
+		duration = c.maxTTL
+	}
+
+	return c.client.Set(ctx, key, 1, duration).Err()
+}
+
+// IsNoData 检查是否缓存了未过期的 NODATA 结果
+func (c *RedisDNSCache) IsNoData(qname string, qtype uint16) bool {
+	ctx := context.Background()
+	key := "dns:nodata:" + c.key(qname, qtype)
+
+	n, err := c.client.Exists(ctx, key).Result()
+	return err == nil && n > 0
+}
+
+// WithSubnet 返回按 ECS 子网隔离的缓存视图
+func (c *RedisDNSCache) WithSubnet(subnet string) DNSCache {
+	if subnet == "" || subnet == c.subnet {
+		return c
+	}
+	return &RedisDNSCache{
+		client: c.client,
+		maxTTL: c.maxTTL,
+		subnet: subnet,
+	}
+}
+
+// key 生成缓存键字符串
+func (c *RedisDNSCache) key(qname string, qtype uint16) string {
+	return CacheKey{
+		Name:   dns.Fqdn(qname),
+		Type:   qtype,
+		Class:  dns.ClassINET,
+		Subnet: c.subnet,
+	}.String()
+}
+
+// Clear 清空所有 DNS 缓存（包括所有子网视图）
 func (c *RedisDNSCache) Clear() error {
 	ctx := context.Background()
 
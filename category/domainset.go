@@ -0,0 +1,234 @@
+package category
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// 分类来源
+const (
+	OriginInline  = "inline"  // 配置中的内联规则
+	OriginPreload = "preload" // DLC 分类和外部规则源
+	OriginLearned = "learned" // 学习到的分类（保存在分类缓存中，按 category_cache.ttl 过期）
+)
+
+// DomainSet 编译后的域名规则集（进程内匹配，启动和定时更新时从 DLC 文件构建）
+// 每次加载生成新一代规则，编译完成后整体替换，匹配过程无锁且始终看到完整的一代；
+// 加载失败时不替换，继续使用当前一代
+type DomainSet struct {
+	rules atomic.Pointer[domainRules]
+}
+
+// domainRules 按匹配类型拆分后的规则（构建完成后只读）
+type domainRules struct {
+	generation uint64            // 代数（每次替换递增，空规则集为 0）
+	full       map[string]string // full 类型: 域名 -> 组名
+	suffix     *domainTrie       // domain 类型: 反转标签后缀树
+	keywords   []keywordRule
+	regexes    []regexRule
+	inline     *domainRules // 配置中直接写的规则，优先于 DLC 和外部规则源（没有时为 nil）
+}
+
+// keywordRule 关键字规则（域名包含该子串即匹配）
+type keywordRule struct {
+	keyword string
+	group   string
+}
+
+// regexRule 正则规则
+type regexRule struct {
+	re    *regexp.Regexp
+	group string
+}
+
+// NewDomainSet 创建空的规则集
+func NewDomainSet() *DomainSet {
+	s := &DomainSet{}
+	s.rules.Store(newDomainRules())
+	return s
+}
+
+// newDomainRules 创建空的规则
+func newDomainRules() *domainRules {
+	return &domainRules{
+		full:   make(map[string]string),
+		suffix: newDomainTrie(),
+	}
+}
+
+// Match 匹配域名（小写，无尾点）
+// 先匹配配置中的内联规则，再匹配 DLC 和外部规则源
+// 每一层的优先级: full 精确匹配 > domain 最长后缀匹配 > keyword > regexp，同类规则按组优先级取第一个
+// 返回匹配的组和来源 (OriginInline / OriginPreload)
+func (s *DomainSet) Match(domain string) (string, string, bool) {
+	rules := s.rules.Load()
+
+	if rules.inline != nil {
+		if group, ok := rules.inline.match(domain); ok {
+			return group, OriginInline, true
+		}
+	}
+	if group, ok := rules.match(domain); ok {
+		return group, OriginPreload, true
+	}
+	return "", "", false
+}
+
+// match 在单层规则中匹配域名
+func (r *domainRules) match(domain string) (string, bool) {
+	if group, ok := r.full[domain]; ok {
+		return group, true
+	}
+
+	if group, ok := r.suffix.match(domain); ok {
+		return group, true
+	}
+
+	for _, rule := range r.keywords {
+		if strings.Contains(domain, rule.keyword) {
+			return rule.group, true
+		}
+	}
+
+	for _, rule := range r.regexes {
+		if rule.re.MatchString(domain) {
+			return rule.group, true
+		}
+	}
+
+	return "", false
+}
+
+// Stats 返回各类型规则数量（包含内联规则）
+func (s *DomainSet) Stats() (full, suffix, keywords, regexes int) {
+	for rules := s.rules.Load(); rules != nil; rules = rules.inline {
+		full += len(rules.full)
+		suffix += rules.suffix.size
+		keywords += len(rules.keywords)
+		regexes += len(rules.regexes)
+	}
+	return full, suffix, keywords, regexes
+}
+
+// entries 以 "类型:值" -> 组名 的形式列出全部规则（用于比较两次加载的差异）
+func (r *domainRules) entries() map[string]string {
+	result := make(map[string]string, len(r.full)+r.suffix.size+len(r.keywords)+len(r.regexes))
+	for domain, group := range r.full {
+		result["full:"+domain] = group
+	}
+	r.suffix.walk(func(domain, group string) {
+		result["domain:"+domain] = group
+	})
+	for _, rule := range r.keywords {
+		result["keyword:"+rule.keyword] = rule.group
+	}
+	for _, rule := range r.regexes {
+		result["regexp:"+rule.re.String()] = rule.group
+	}
+	if r.inline != nil {
+		for rule, group := range r.inline.entries() {
+			result["inline "+rule] = group
+		}
+	}
+	return result
+}
+
+// Generation 返回当前生效的规则代数
+func (s *DomainSet) Generation() uint64 {
+	return s.rules.Load().generation
+}
+
+// replace 以新一代规则替换全部规则，返回新的代数
+// 仅由加载器和更新器调用（同一时间只有一个写入方）
+func (s *DomainSet) replace(rules *domainRules) uint64 {
+	rules.generation = s.rules.Load().generation + 1
+	s.rules.Store(rules)
+	return rules.generation
+}
+
+// domainTrie 反转标签的域名后缀树（www.google.com 按 com -> google -> www 存储）
+type domainTrie struct {
+	root *trieNode
+	size int
+}
+
+// trieNode 后缀树节点
+type trieNode struct {
+	children map[string]*trieNode
+	group    string
+	terminal bool // 该节点对应的域名是否为规则
+}
+
+// newDomainTrie 创建空的后缀树
+func newDomainTrie() *domainTrie {
+	return &domainTrie{root: &trieNode{}}
+}
+
+// insert 插入域名后缀规则，已存在时保留原有组（先插入的组优先级更高）并返回原有组和 false
+func (t *domainTrie) insert(domain, group string) (string, bool) {
+	node := t.root
+	for end := len(domain); end > 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[start:end]
+
+		child, ok := node.children[label]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[label] = child
+		}
+		node = child
+		end = start - 1
+	}
+
+	if node.terminal {
+		return node.group, false
+	}
+	node.terminal = true
+	node.group = group
+	t.size++
+	return group, true
+}
+
+// match 返回匹配的最长后缀规则的组
+func (t *domainTrie) match(domain string) (string, bool) {
+	node := t.root
+	group, matched := "", false
+
+	for end := len(domain); end > 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+
+		child, ok := node.children[domain[start:end]]
+		if !ok {
+			break
+		}
+		node = child
+		if node.terminal {
+			group, matched = node.group, true
+		}
+		end = start - 1
+	}
+
+	return group, matched
+}
+
+// walk 遍历后缀树中的全部规则
+func (t *domainTrie) walk(fn func(domain, group string)) {
+	var visit func(node *trieNode, domain string)
+	visit = func(node *trieNode, domain string) {
+		if node.terminal {
+			fn(domain, node.group)
+		}
+		for label, child := range node.children {
+			if domain == "" {
+				visit(child, label)
+			} else {
+				visit(child, label+"."+domain)
+			}
+		}
+	}
+	visit(t.root, "")
+}
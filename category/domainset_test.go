@@ -0,0 +1,245 @@
+package category
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"violet-dns/component/geodata/router"
+)
+
+// newTestRules 编译测试规则（组优先级按 groupOrder 顺序）
+func newTestRules(groupOrder []string, domainGroups map[string][]*router.Domain) *domainRules {
+	rules, _ := compile(groupOrder, domainGroups)
+	return rules
+}
+
+func TestDomainSetMatchPrecedence(t *testing.T) {
+	rules := newTestRules([]string{"first", "second"}, map[string][]*router.Domain{
+		"first": {
+			newDomain(router.Domain_Domain, "google.com"),
+			newDomain(router.Domain_Plain, "ads"),
+			newDomain(router.Domain_Regex, `^cdn\d+\.`),
+		},
+		"second": {
+			newDomain(router.Domain_Full, "www.google.com"),
+			newDomain(router.Domain_Domain, "mail.google.com"),
+			newDomain(router.Domain_Domain, "google.com"), // 与 first 冲突，first 优先
+			newDomain(router.Domain_Plain, "ads"),         // 与 first 冲突，first 优先
+			newDomain(router.Domain_Plain, "track"),
+			newDomain(router.Domain_Regex, `\.example\.org$`),
+		},
+	})
+	set := NewDomainSet()
+	set.replace(rules)
+
+	tests := []struct {
+		domain string
+		group  string
+		ok     bool
+	}{
+		{"www.google.com", "second", true},      // full 优先于 domain
+		{"google.com", "first", true},           // domain 自身
+		{"a.b.google.com", "first", true},       // domain 子域名
+		{"mail.google.com", "second", true},     // 最长后缀优先
+		{"x.mail.google.com", "second", true},   // 最长后缀优先（子域名）
+		{"notgoogle.com", "", false},            // 后缀按标签匹配，不是子串
+		{"ads.google.com", "first", true},       // domain 优先于 keyword
+		{"ads.example.net", "first", true},      // keyword，同类规则按组优先级
+		{"track.example.net", "second", true},   // keyword
+		{"cdn12.example.org", "first", true},    // keyword 不匹配时按 regexp 顺序
+		{"static.example.org", "second", true},  // regexp
+		{"trackads.example.org", "first", true}, // keyword 优先于 regexp，first 的 keyword 在前
+		{"example.net", "", false},
+	}
+
+	for _, tt := range tests {
+		group, origin, ok := set.Match(tt.domain)
+		if group != tt.group || ok != tt.ok {
+			t.Errorf("Match(%s) = %s, %v, 期望 %s, %v", tt.domain, group, ok, tt.group, tt.ok)
+		}
+		if ok && origin != OriginPreload {
+			t.Errorf("Match(%s) 来源 = %s, 期望 %s", tt.domain, origin, OriginPreload)
+		}
+	}
+}
+
+func TestDomainSetInlinePrecedence(t *testing.T) {
+	rules := newTestRules([]string{"proxy"}, map[string][]*router.Domain{
+		"proxy": {newDomain(router.Domain_Full, "www.google.com")},
+	})
+	rules.inline = newTestRules([]string{"direct"}, map[string][]*router.Domain{
+		"direct": {newDomain(router.Domain_Domain, "google.com")},
+	})
+	set := NewDomainSet()
+	set.replace(rules)
+
+	// 内联规则整体优先于 DLC 规则，即使 DLC 中是更精确的 full 规则
+	if group, origin, _ := set.Match("www.google.com"); group != "direct" || origin != OriginInline {
+		t.Errorf("Match(www.google.com) = %s (%s), 期望 direct (%s)", group, origin, OriginInline)
+	}
+
+	full, suffix, _, _ := set.Stats()
+	if full != 1 || suffix != 1 {
+		t.Errorf("Stats() = full %d suffix %d, 期望 1 1", full, suffix)
+	}
+}
+
+func TestDomainTrie(t *testing.T) {
+	trie := newDomainTrie()
+	if _, ok := trie.insert("google.com", "a"); !ok {
+		t.Fatal("首次插入应成功")
+	}
+	if winner, ok := trie.insert("google.com", "b"); ok || winner != "a" {
+		t.Fatalf("重复插入 = %s, %v, 期望保留 a", winner, ok)
+	}
+	trie.insert("com", "tld")
+
+	tests := []struct {
+		domain string
+		group  string
+		ok     bool
+	}{
+		{"google.com", "a", true},
+		{"www.google.com", "a", true},
+		{"example.com", "tld", true},
+		{"com", "tld", true},
+		{"google.net", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		group, ok := trie.match(tt.domain)
+		if group != tt.group || ok != tt.ok {
+			t.Errorf("match(%s) = %s, %v, 期望 %s, %v", tt.domain, group, ok, tt.group, tt.ok)
+		}
+	}
+
+	if trie.size != 2 {
+		t.Errorf("size = %d, 期望 2", trie.size)
+	}
+
+	walked := make(map[string]string)
+	trie.walk(func(domain, group string) { walked[domain] = group })
+	if len(walked) != 2 || walked["google.com"] != "a" || walked["com"] != "tld" {
+		t.Errorf("walk = %v", walked)
+	}
+}
+
+func TestDomainSetGenerationSwap(t *testing.T) {
+	set := NewDomainSet()
+	if set.Generation() != 0 {
+		t.Fatalf("空规则集代数 = %d, 期望 0", set.Generation())
+	}
+
+	// 每一代把两个域名都放进同一个组，匹配时看到的两个结果必须来自同一代
+	build := func(i int) *domainRules {
+		group := fmt.Sprintf("gen%d", i)
+		return newTestRules([]string{group}, map[string][]*router.Domain{
+			group: {
+				newDomain(router.Domain_Full, "a.example.com"),
+				newDomain(router.Domain_Domain, "b.example.com"),
+			},
+		})
+	}
+	set.replace(build(0))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				rules := set.rules.Load()
+				a, _ := rules.match("a.example.com")
+				b, _ := rules.match("x.b.example.com")
+				if a != b {
+					t.Errorf("同一代规则的匹配结果不一致: %s != %s", a, b)
+					return
+				}
+				if _, _, ok := set.Match("a.example.com"); !ok {
+					t.Error("替换过程中匹配失败")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 100; i++ {
+		if gen := set.replace(build(i)); gen != uint64(i+1) {
+			t.Fatalf("replace 返回代数 %d, 期望 %d", gen, i+1)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if group, _, _ := set.Match("a.example.com"); group != "gen100" {
+		t.Errorf("最终匹配 = %s, 期望 gen100", group)
+	}
+}
+
+// benchmarkGroups 生成接近完整 dlc.dat 规模的规则（约 12 万条，分布与 geosite 相近）
+func benchmarkGroups() ([]string, map[string][]*router.Domain) {
+	groupOrder := []string{"cn_site", "proxy_site", "block_site"}
+	groups := make(map[string][]*router.Domain, len(groupOrder))
+
+	for i := 0; i < 100000; i++ {
+		group := groupOrder[i%len(groupOrder)]
+		groups[group] = append(groups[group], newDomain(router.Domain_Domain, fmt.Sprintf("site%d.example%d.com", i, i%100)))
+	}
+	for i := 0; i < 20000; i++ {
+		group := groupOrder[i%len(groupOrder)]
+		groups[group] = append(groups[group], newDomain(router.Domain_Full, fmt.Sprintf("www.full%d.net", i)))
+	}
+	for i := 0; i < 200; i++ {
+		group := groupOrder[i%len(groupOrder)]
+		groups[group] = append(groups[group], newDomain(router.Domain_Plain, fmt.Sprintf("keyword%d", i)))
+	}
+	for i := 0; i < 50; i++ {
+		group := groupOrder[i%len(groupOrder)]
+		groups[group] = append(groups[group], newDomain(router.Domain_Regex, fmt.Sprintf(`^regex%d-\d+\.org$`, i)))
+	}
+
+	return groupOrder, groups
+}
+
+func BenchmarkDomainSetBuild(b *testing.B) {
+	groupOrder, groups := benchmarkGroups()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		compile(groupOrder, groups)
+	}
+}
+
+func BenchmarkDomainSetMatch(b *testing.B) {
+	set := NewDomainSet()
+	set.replace(newTestRules(benchmarkGroups()))
+
+	domains := []string{
+		"www.full123.net",              // full
+		"a.b.site4567.example67.com",   // domain 后缀
+		"cdn.keyword150.example.io",    // keyword
+		"regex7-42.org",                // regexp
+		"unmatched.subdomain.test.dev", // 未匹配（遍历全部 keyword 和 regexp）
+	}
+
+	for _, domain := range domains {
+		if _, _, ok := set.Match(domain); !ok && domain != "unmatched.subdomain.test.dev" {
+			b.Fatalf("%s 应该匹配", domain)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Match(domains[i%len(domains)])
+	}
+}
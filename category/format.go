@@ -0,0 +1,274 @@
+package category
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"violet-dns/component/geodata/router"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormatParser 外部规则格式解析器，将文件内容转换为域名规则
+type FormatParser func(data []byte) ([]*router.Domain, error)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]FormatParser{
+		"list":    parseListFormat,
+		"hosts":   parseHostsFormat,
+		"adblock": parseAdblockFormat,
+		"adguard": parseAdblockFormat,
+		"clash":   parseClashFormat,
+		"srs":     parseSRSFormat,
+	}
+)
+
+// RegisterFormat 注册外部规则格式（名称不区分大小写，已存在时覆盖）
+// 注册后 domain_group 中可以使用 "名称:file:..." 或 "名称:url:..." 引用该格式的规则源
+func RegisterFormat(name string, parser FormatParser) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	formats[strings.ToLower(name)] = parser
+}
+
+// lookupFormat 查找格式解析器
+func lookupFormat(name string) (FormatParser, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	parser, ok := formats[strings.ToLower(name)]
+	return parser, ok
+}
+
+// newDomain 创建域名规则
+func newDomain(domainType router.Domain_Type, value string) *router.Domain {
+	return &router.Domain{Type: domainType, Value: value}
+}
+
+// forEachLine 逐行处理文本（去除首尾空白，跳过空行）
+func forEachLine(data []byte, fn func(line string)) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseListFormat 解析纯域名列表
+// 每行一个域名（匹配该域名及子域名），支持 # 注释和 full:/domain:/keyword:/regexp: 前缀
+func parseListFormat(data []byte) ([]*router.Domain, error) {
+	var domains []*router.Domain
+	err := forEachLine(data, func(line string) {
+		if strings.HasPrefix(line, "#") {
+			return
+		}
+		// 去掉行尾注释和属性（例如 "google.com @ads"），正则中可能包含空白，保留整行
+		if !strings.HasPrefix(line, "regexp:") {
+			if i := strings.IndexAny(line, " \t"); i >= 0 {
+				line = line[:i]
+			}
+		}
+
+		prefix, value, ok := strings.Cut(line, ":")
+		if !ok {
+			domains = append(domains, newDomain(router.Domain_Domain, strings.TrimPrefix(line, ".")))
+			return
+		}
+
+		switch prefix {
+		case "full":
+			domains = append(domains, newDomain(router.Domain_Full, value))
+		case "domain":
+			domains = append(domains, newDomain(router.Domain_Domain, value))
+		case "keyword":
+			domains = append(domains, newDomain(router.Domain_Plain, value))
+		case "regexp":
+			domains = append(domains, newDomain(router.Domain_Regex, value))
+		}
+	})
+	return domains, err
+}
+
+// hostsIgnored hosts 文件中的本地主机名
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// parseHostsFormat 解析 hosts 文件（"IP 主机名..."，主机名完整匹配）
+func parseHostsFormat(data []byte) ([]*router.Domain, error) {
+	var domains []*router.Domain
+	err := forEachLine(data, func(line string) {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			return
+		}
+
+		for _, host := range fields[1:] {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			if host == "" || hostsIgnored[host] {
+				continue
+			}
+			domains = append(domains, newDomain(router.Domain_Full, host))
+		}
+	})
+	return domains, err
+}
+
+// adblockHost adblock 规则中的主机名
+var adblockHost = regexp.MustCompile(`^[a-z0-9*_-]+(\.[a-z0-9*_-]+)*$`)
+
+// parseAdblockFormat 解析 AdGuard/adblock 过滤规则
+// 支持 ||example.com^（域名及子域名）、|example.com^（完整匹配）、/正则/ 和纯主机名；
+// 例外规则 (@@)、元素隐藏规则和带路径的 URL 规则无法在 DNS 层表达，直接跳过
+func parseAdblockFormat(data []byte) ([]*router.Domain, error) {
+	var domains []*router.Domain
+	err := forEachLine(data, func(line string) {
+		if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") ||
+			strings.HasPrefix(line, "@@") || strings.Contains(line, "##") || strings.Contains(line, "#@#") {
+			return
+		}
+
+		// 正则规则
+		if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			domains = append(domains, newDomain(router.Domain_Regex, line[1:len(line)-1]))
+			return
+		}
+
+		// 去掉修饰符（$important 等）
+		if i := strings.IndexByte(line, '$'); i >= 0 {
+			line = line[:i]
+		}
+
+		domainType := router.Domain_Domain
+		switch {
+		case strings.HasPrefix(line, "||"):
+			line = line[2:]
+		case strings.HasPrefix(line, "|"):
+			line = line[1:]
+			domainType = router.Domain_Full
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "|"), "^")
+		line = strings.ToLower(line)
+
+		if !adblockHost.MatchString(line) || strings.Trim(line, "*.") == "" {
+			return // 带路径或端口的 URL 规则，或匹配全部域名的通配规则
+		}
+
+		if strings.Contains(line, "*") {
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(line), `\*`, `.*`)
+			if domainType == router.Domain_Full {
+				pattern = "^" + pattern + "$"
+			} else {
+				pattern = `(^|\.)` + pattern + "$"
+			}
+			domains = append(domains, newDomain(router.Domain_Regex, pattern))
+			return
+		}
+
+		domains = append(domains, newDomain(domainType, line))
+	})
+	return domains, err
+}
+
+// parseClashFormat 解析 Clash rule-provider（YAML payload 或纯文本，domain 和 classical 两种 behavior）
+func parseClashFormat(data []byte) ([]*router.Domain, error) {
+	var provider struct {
+		Payload []string `yaml:"payload"`
+	}
+	if err := yaml.Unmarshal(data, &provider); err != nil || provider.Payload == nil {
+		// 纯文本格式
+		provider.Payload = nil
+		err := forEachLine(data, func(line string) {
+			if !strings.HasPrefix(line, "#") {
+				provider.Payload = append(provider.Payload, line)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var domains []*router.Domain
+	for _, item := range provider.Payload {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, ",") {
+			if domain := parseClashClassical(item); domain != nil {
+				domains = append(domains, domain)
+			}
+			continue
+		}
+
+		domains = append(domains, parseClashDomain(strings.Trim(item, `'"`)))
+	}
+	return domains, nil
+}
+
+// parseClashDomain 解析 domain behavior 的条目
+//   - "+.google.com" - google.com 及其子域名
+//   - ".google.com" - 仅子域名
+//   - "*.google.com" - 一级子域名
+//   - "google.com" - 完整匹配
+func parseClashDomain(item string) *router.Domain {
+	switch {
+	case strings.HasPrefix(item, "+."):
+		return newDomain(router.Domain_Domain, item[2:])
+	case strings.HasPrefix(item, "."):
+		return newDomain(router.Domain_Regex, regexp.QuoteMeta(item)+"$")
+	case strings.Contains(item, "*"):
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(item), `\*`, `[^.]+`)
+		return newDomain(router.Domain_Regex, "^"+pattern+"$")
+	default:
+		return newDomain(router.Domain_Full, item)
+	}
+}
+
+// parseClashClassical 解析 classical behavior 的条目，非域名规则返回 nil
+func parseClashClassical(item string) *router.Domain {
+	fields := strings.Split(item, ",")
+	if len(fields) < 2 {
+		return nil
+	}
+
+	value := strings.TrimSpace(fields[1])
+	switch strings.ToUpper(strings.TrimSpace(fields[0])) {
+	case "DOMAIN":
+		return newDomain(router.Domain_Full, value)
+	case "DOMAIN-SUFFIX":
+		return newDomain(router.Domain_Domain, strings.TrimPrefix(value, "."))
+	case "DOMAIN-KEYWORD":
+		return newDomain(router.Domain_Plain, value)
+	case "DOMAIN-REGEX":
+		return newDomain(router.Domain_Regex, value)
+	default:
+		return nil
+	}
+}
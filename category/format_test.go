@@ -0,0 +1,153 @@
+package category
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"violet-dns/component/geodata/router"
+)
+
+// domainStrings 将规则转换为排序后的 "类型:值" 列表，便于比较
+func domainStrings(domains []*router.Domain) []string {
+	names := map[router.Domain_Type]string{
+		router.Domain_Full:   "full",
+		router.Domain_Domain: "domain",
+		router.Domain_Plain:  "keyword",
+		router.Domain_Regex:  "regexp",
+	}
+	result := make([]string, 0, len(domains))
+	for _, d := range domains {
+		result = append(result, names[d.Type]+":"+d.Value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// expectDomains 检查解析结果（不关心顺序）
+func expectDomains(t *testing.T, name string, got []*router.Domain, want []string) {
+	t.Helper()
+	sort.Strings(want)
+	if g := domainStrings(got); strings.Join(g, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s 解析结果:\n%s\n期望:\n%s", name, strings.Join(g, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseTextFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+		want   []string
+	}{
+		{
+			format: "list",
+			input: `# 注释
+google.com
+.example.org
+full:www.example.com # 行尾注释
+domain:example.net @ads
+keyword:tracker
+regexp:^ads\d+\.example\.com$
+regexp:^(a|b) [0-9]+$
+unknown:ignored.example
+`,
+			want: []string{
+				"domain:google.com",
+				"domain:example.org",
+				"full:www.example.com",
+				"domain:example.net",
+				"keyword:tracker",
+				`regexp:^ads\d+\.example\.com$`,
+				"regexp:^(a|b) [0-9]+$", // 正则中的空白不是注释
+			},
+		},
+		{
+			format: "hosts",
+			input: `127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # 注释
+::1 ip6-localhost
+# 0.0.0.0 commented.example
+not-an-ip foo.example
+0.0.0.0 Upper.Example.COM.
+`,
+			want: []string{
+				"full:ads.example.com",
+				"full:tracker.example.com",
+				"full:upper.example.com",
+			},
+		},
+		{
+			format: "adblock",
+			input: `! 注释
+[Adblock Plus 2.0]
+||ads.example.com^
+|exact.example.com^
+||Tracker.Example.org^$important
+@@||allowed.example.com^
+example.com##.banner
+/^ad[0-9]+\./
+||example.com/path
+||*.cdn.example.net^
+|*.full.example^
+||*^
+plain.example.com
+`,
+			want: []string{
+				"domain:ads.example.com",
+				"full:exact.example.com",
+				"domain:tracker.example.org",
+				`regexp:^ad[0-9]+\.`,
+				`regexp:(^|\.).*\.cdn\.example\.net$`,
+				`regexp:^.*\.full\.example$`,
+				"domain:plain.example.com",
+			},
+		},
+		{
+			format: "clash",
+			input: `payload:
+  - '+.google.com'
+  - '.cdn.example.net'
+  - '*.example.org'
+  - 'www.example.com'
+  - DOMAIN-SUFFIX,example.net
+  - DOMAIN,exact.example
+  - DOMAIN-KEYWORD,tracker
+  - DOMAIN-REGEX,^ads\d+$
+  - IP-CIDR,10.0.0.0/8
+`,
+			want: []string{
+				"domain:google.com",
+				`regexp:\.cdn\.example\.net$`,
+				`regexp:^[^.]+\.example\.org$`,
+				"full:www.example.com",
+				"domain:example.net",
+				"full:exact.example",
+				"keyword:tracker",
+				`regexp:^ads\d+$`,
+			},
+		},
+		{
+			format: "clash",
+			input: `# 纯文本格式
++.google.com
+DOMAIN-SUFFIX,example.net
+`,
+			want: []string{
+				"domain:google.com",
+				"domain:example.net",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		parser, ok := lookupFormat(strings.ToUpper(tt.format))
+		if !ok {
+			t.Fatalf("未注册格式 %s", tt.format)
+		}
+		domains, err := parser([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		expectDomains(t, tt.format, domains, tt.want)
+	}
+}
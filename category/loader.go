@@ -2,91 +2,171 @@ package category
 
 import (
 	"fmt"
-)
+	"log"
+	"regexp"
+	"strings"
 
-// CategoryCache 分类缓存接口
-type CategoryCache interface {
-	Set(domain, category string) error
-	Get(domain string) (string, error)
-	BatchSet(data map[string]string) error
-}
+	"violet-dns/component/geodata/router"
+	"violet-dns/config"
+)
 
-// Loader 域名分类加载器
+// Loader 域名分类加载器（将 DLC 文件和外部规则源编译为进程内规则集）
 type Loader struct {
-	parser *Parser
-	cache  CategoryCache
+	parser  *Parser
+	sources *Sources
+	domains *DomainSet
 }
 
 // NewLoader 创建新的加载器
-func NewLoader(cache CategoryCache) *Loader {
+func NewLoader(domains *DomainSet, sources *Sources) *Loader {
 	return &Loader{
-		parser: NewParser(),
-		cache:  cache,
+		parser:  NewParser(sources),
+		sources: sources,
+		domains: domains,
+	}
+}
+
+// Load 加载域名分类数据，编译完成后整体替换规则集
+// 同一规则出现在多个组时，domain_group 中靠前的组优先，冲突汇总写入日志
+func (l *Loader) Load(filename string, domainGroupConfig config.DomainGroups) error {
+	rules, err := l.build(filename, domainGroupConfig)
+	if err != nil {
+		return err
 	}
+
+	l.domains.replace(rules)
+	return nil
 }
 
-// Load 加载域名分类数据
-func (l *Loader) Load(filename string, domainGroupConfig map[string][]string) error {
+// build 解析 DLC 文件并编译规则（不替换当前规则集）
+func (l *Loader) build(filename string, domainGroupConfig config.DomainGroups) (*domainRules, error) {
 	// 解析 DLC 文件 (返回 map[string][]*router.Domain)
 	dlcData, err := l.parser.Parse(filename)
 	if err != nil {
-		return fmt.Errorf("解析 DLC 文件失败: %w", err)
+		return nil, fmt.Errorf("解析 DLC 文件失败: %w", err)
 	}
 
-	// 解析域名组配置 (返回 map[string][]string)
-	domainGroups, err := l.parser.ParseDomainGroup(dlcData, domainGroupConfig)
+	// 解析域名组配置 (返回 map[string][]*router.Domain，内联规则单独返回)
+	domainGroups, inlineGroups, err := l.parser.ParseDomainGroup(dlcData, domainGroupConfig)
 	if err != nil {
-		return fmt.Errorf("解析域名组配置失败: %w", err)
+		return nil, fmt.Errorf("解析域名组配置失败: %w", err)
 	}
 
-	// 批量写入缓存
-	batchData := make(map[string]string)
-	for groupName, domains := range domainGroups {
-		for _, domain := range domains {
-			batchData[domain] = groupName
-		}
+	// 按配置文件中的顺序排列（越靠前优先级越高）
+	groupOrder := make([]string, 0, len(domainGroupConfig))
+	for _, group := range domainGroupConfig {
+		groupOrder = append(groupOrder, group.Name)
 	}
 
-	if err := l.cache.BatchSet(batchData); err != nil {
-		return fmt.Errorf("写入缓存失败: %w", err)
+	rules, conflicts := compile(groupOrder, domainGroups)
+	logConflicts(conflicts)
+
+	// 内联规则单独编译为更高优先级的一层
+	if len(inlineGroups) > 0 {
+		inline, inlineConflicts := compile(groupOrder, inlineGroups)
+		logConflicts(inlineConflicts)
+		rules.inline = inline
 	}
 
-	return nil
+	return rules, nil
 }
 
-// LoadReverse 倒序加载域名分类数据（确保正序时上级分流能覆盖下级分类）
-func (l *Loader) LoadReverse(filename string, domainGroupConfig map[string][]string) error {
-	// 解析 DLC 文件 (返回 map[string][]*router.Domain)
-	dlcData, err := l.parser.Parse(filename)
-	if err != nil {
-		return fmt.Errorf("解析 DLC 文件失败: %w", err)
+// conflict 同一规则出现在多个组
+type conflict struct {
+	rule   string // 规则（类型:值）
+	winner string // 生效的组
+	loser  string // 被忽略的组
+}
+
+// maxConflictExamples 每对冲突组在日志中列出的规则示例数量
+const maxConflictExamples = 3
+
+// logConflicts 按 (生效组, 被忽略组) 汇总冲突并写入日志
+func logConflicts(conflicts []conflict) {
+	if len(conflicts) == 0 {
+		return
 	}
 
-	// 解析域名组配置 (返回 map[string][]string)
-	domainGroups, err := l.parser.ParseDomainGroup(dlcData, domainGroupConfig)
-	if err != nil {
-		return fmt.Errorf("解析域名组配置失败: %w", err)
+	type pair struct{ winner, loser string }
+	var pairs []pair
+	examples := make(map[pair][]string)
+	counts := make(map[pair]int)
+
+	for _, c := range conflicts {
+		key := pair{winner: c.winner, loser: c.loser}
+		if counts[key] == 0 {
+			pairs = append(pairs, key)
+		}
+		counts[key]++
+		if len(examples[key]) < maxConflictExamples {
+			examples[key] = append(examples[key], c.rule)
+		}
 	}
 
-	// 获取 domainGroupConfig 的键顺序（用于倒序遍历）
-	var groupOrder []string
-	for groupName := range domainGroupConfig {
-		groupOrder = append(groupOrder, groupName)
+	log.Printf("域名分类存在 %d 条跨组重复规则，按 domain_group 顺序保留靠前的组\n", len(conflicts))
+	for _, key := range pairs {
+		log.Printf("  %s 优先于 %s: %d 条 (例如 %s)\n",
+			key.winner, key.loser, counts[key], strings.Join(examples[key], ", "))
 	}
+}
 
-	// 倒序遍历并写入缓存（后面的组先写入，前面的组覆盖）
-	batchData := make(map[string]string)
-	for i := len(groupOrder) - 1; i >= 0; i-- {
-		groupName := groupOrder[i]
-		domains := domainGroups[groupName]
-		for _, domain := range domains {
-			batchData[domain] = groupName
+// compile 按组优先级编译规则，同一规则出现在多个组时保留优先级最高的组，并返回跨组冲突
+func compile(groupOrder []string, domainGroups map[string][]*router.Domain) (*domainRules, []conflict) {
+	rules := newDomainRules()
+	keywordGroups := make(map[string]string)
+	regexGroups := make(map[string]string)
+	var conflicts []conflict
+
+	// 记录冲突（同一组内的重复规则不算冲突）
+	addConflict := func(rule, winner, loser string) {
+		if winner != loser {
+			conflicts = append(conflicts, conflict{rule: rule, winner: winner, loser: loser})
 		}
 	}
 
-	if err := l.cache.BatchSet(batchData); err != nil {
-		return fmt.Errorf("写入缓存失败: %w", err)
+	for _, groupName := range groupOrder {
+		for _, domain := range domainGroups[groupName] {
+			value := strings.ToLower(domain.GetValue())
+			if value == "" {
+				continue
+			}
+
+			switch domain.GetType() {
+			case router.Domain_Full:
+				if winner, exists := rules.full[value]; exists {
+					addConflict("full:"+value, winner, groupName)
+					continue
+				}
+				rules.full[value] = groupName
+			case router.Domain_Domain:
+				if winner, inserted := rules.suffix.insert(value, groupName); !inserted {
+					addConflict("domain:"+value, winner, groupName)
+				}
+			case router.Domain_Plain:
+				if winner, exists := keywordGroups[value]; exists {
+					addConflict("keyword:"+value, winner, groupName)
+					continue
+				}
+				keywordGroups[value] = groupName
+				rules.keywords = append(rules.keywords, keywordRule{keyword: value, group: groupName})
+			case router.Domain_Regex:
+				// 正则区分大小写，使用原始值
+				pattern := domain.GetValue()
+				if winner, exists := regexGroups[pattern]; exists {
+					addConflict("regexp:"+pattern, winner, groupName)
+					continue
+				}
+				regexGroups[pattern] = groupName
+
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					log.Printf("警告: 跳过无效的正则规则 %s (组 %s): %v\n", pattern, groupName, err)
+					continue
+				}
+				rules.regexes = append(rules.regexes, regexRule{re: re, group: groupName})
+			}
+		}
 	}
 
-	return nil
+	return rules, conflicts
 }
@@ -5,18 +5,22 @@ import (
 	"io"
 	"log"
 	"os"
+	"regexp"
 	"strings"
 
 	"google.golang.org/protobuf/proto"
 	"violet-dns/component/geodata/router"
+	"violet-dns/config"
 )
 
-// Parser DLC 文件解析器
-type Parser struct{}
+// Parser DLC 文件解析器（域名组中的外部规则源由 sources 读取）
+type Parser struct {
+	sources *Sources
+}
 
 // NewParser 创建新的解析器
-func NewParser() *Parser {
-	return &Parser{}
+func NewParser(sources *Sources) *Parser {
+	return &Parser{sources: sources}
 }
 
 // Parse 解析 DLC 文件 (protobuf 格式)
@@ -55,24 +59,94 @@ func (p *Parser) readFile(filename string) ([]byte, error) {
 	return io.ReadAll(file)
 }
 
-// ParseDomainGroup 解析域名组配置，支持属性过滤
-func (p *Parser) ParseDomainGroup(dlcData map[string][]*router.Domain, groupConfig map[string][]string) (map[string][]string, error) {
-	result := make(map[string][]string)
+// ParseDomainGroup 解析域名组配置，支持属性过滤、外部规则源和内联规则（保留每个域名的匹配类型）
+// 返回 DLC 分类和外部规则源的域名，以及单独返回的内联规则（优先级更高）
+func (p *Parser) ParseDomainGroup(dlcData map[string][]*router.Domain, groupConfig config.DomainGroups) (map[string][]*router.Domain, map[string][]*router.Domain, error) {
+	result := make(map[string][]*router.Domain)
+	inline := make(map[string][]*router.Domain)
 
-	for groupName, categorySpecs := range groupConfig {
-		domains := []string{}
-		for _, spec := range categorySpecs {
-			// 解析 spec: 支持 "category@attr1@attr2" 或 "category" 格式
-			categoryDomains, err := p.parseCategorySpec(dlcData, spec)
+	for _, group := range groupConfig {
+		domains := []*router.Domain{}
+		for _, entry := range group.Entries {
+			// 内联规则: full:/domain:/keyword:/regexp:
+			domain, isInline, err := parseInlineRule(entry.Source)
 			if err != nil {
-				return nil, fmt.Errorf("解析分类 %s 失败: %w", spec, err)
+				return nil, nil, err
+			}
+			if isInline {
+				inline[group.Name] = append(inline[group.Name], domain)
+				continue
 			}
-			domains = append(domains, categoryDomains...)
+
+			entryDomains, err := p.parseEntry(dlcData, entry.Source)
+			if err != nil {
+				return nil, nil, fmt.Errorf("解析分类 %s 失败: %w", entry.Source, err)
+			}
+			domains = append(domains, entryDomains...)
 		}
-		result[groupName] = domains
+		result[group.Name] = domains
 	}
 
-	return result, nil
+	return result, inline, nil
+}
+
+// inlineRuleTypes 内联规则前缀对应的匹配类型
+var inlineRuleTypes = map[string]router.Domain_Type{
+	"full":    router.Domain_Full,
+	"domain":  router.Domain_Domain,
+	"keyword": router.Domain_Plain,
+	"regexp":  router.Domain_Regex,
+}
+
+// parseInlineRule 解析内联规则，不是内联规则时返回 false
+// 格式:
+//   - "full:example.com" - 只匹配 example.com
+//   - "domain:example.com" - 匹配 example.com 及其子域名
+//   - "keyword:example" - 匹配包含 example 的域名
+//   - "regexp:^cdn\d+\." - 匹配符合正则的域名
+func parseInlineRule(spec string) (*router.Domain, bool, error) {
+	prefix, value, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return nil, false, nil
+	}
+
+	domainType, ok := inlineRuleTypes[strings.ToLower(prefix)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, true, fmt.Errorf("内联规则 %s 缺少值", spec)
+	}
+
+	switch domainType {
+	case router.Domain_Regex:
+		if _, err := regexp.Compile(value); err != nil {
+			return nil, true, fmt.Errorf("内联规则 %s 的正则无效: %w", spec, err)
+		}
+	default:
+		value = strings.TrimSuffix(strings.ToLower(value), ".")
+	}
+
+	return &router.Domain{Type: domainType, Value: value}, true, nil
+}
+
+// parseEntry 解析域名组条目：外部规则源 (file:/url:) 或 DLC 分类
+func (p *Parser) parseEntry(dlcData map[string][]*router.Domain, spec string) ([]*router.Domain, error) {
+	src, isSource, err := parseSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	if isSource {
+		if p.sources == nil {
+			return nil, fmt.Errorf("未配置外部规则源")
+		}
+		return p.sources.load(src)
+	}
+
+	// 解析 spec: 支持 "category@attr1@attr2" 或 "category" 格式
+	return p.parseCategorySpec(dlcData, spec)
 }
 
 // parseCategorySpec 解析单个分类规则，支持属性过滤和取反
@@ -81,7 +155,7 @@ func (p *Parser) ParseDomainGroup(dlcData map[string][]*router.Domain, groupConf
 //   - "geolocation-!cn" - 匹配 geolocation-!cn 分类
 //   - "geolocation-cn@!cn" - 匹配 geolocation-cn 分类中不包含 cn 属性的域名
 //   - "geolocation-cn@cn" - 匹配 geolocation-cn 分类中包含 cn 属性的域名
-func (p *Parser) parseCategorySpec(dlcData map[string][]*router.Domain, spec string) ([]string, error) {
+func (p *Parser) parseCategorySpec(dlcData map[string][]*router.Domain, spec string) ([]*router.Domain, error) {
 	spec = strings.TrimSpace(spec)
 	if spec == "" {
 		return nil, fmt.Errorf("分类规则不能为空")
@@ -101,7 +175,7 @@ func (p *Parser) parseCategorySpec(dlcData map[string][]*router.Domain, spec str
 
 	// 如果没有属性过滤，返回所有域名
 	if len(attrFilters) == 0 {
-		return p.extractDomainValues(domainList), nil
+		return domainList, nil
 	}
 
 	// 应用属性过滤
@@ -110,7 +184,7 @@ func (p *Parser) parseCategorySpec(dlcData map[string][]*router.Domain, spec str
 		log.Printf("警告: 分类 %s 应用属性过滤后没有匹配的域名\n", spec)
 	}
 
-	return p.extractDomainValues(filtered), nil
+	return filtered, nil
 }
 
 // filterDomainsByAttributes 根据属性过滤域名
@@ -174,12 +248,3 @@ func (p *Parser) hasAttribute(domain *router.Domain, attrKey string) bool {
 	}
 	return false
 }
-
-// extractDomainValues 从 Domain 列表中提取域名字符串
-func (p *Parser) extractDomainValues(domains []*router.Domain) []string {
-	result := make([]string, 0, len(domains))
-	for _, domain := range domains {
-		result = append(result, domain.Value)
-	}
-	return result
-}
@@ -0,0 +1,157 @@
+package category
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"violet-dns/component/geodata/router"
+	"violet-dns/utils"
+)
+
+// 外部规则源类型
+const (
+	sourceFile = "file" // 本地文件
+	sourceURL  = "url"  // 远程文件（下载到本地缓存目录）
+)
+
+// source 外部规则源
+// 格式: [格式:]file:路径 或 [格式:]url:地址，省略格式时按扩展名推断
+//   - "file:rules/proxy.txt" - 纯域名列表
+//   - "hosts:file:/etc/hosts" - hosts 文件
+//   - "adblock:url:https://example.com/filter.txt" - AdGuard/adblock 过滤规则
+//   - "clash:url:https://example.com/proxy.yaml" - Clash rule-provider
+//   - "url:https://example.com/geosite-google.srs" - sing-box 二进制规则集
+type source struct {
+	spec     string
+	format   string
+	kind     string // file 或 url
+	location string
+}
+
+// parseSource 解析外部规则源，不是外部规则源时返回 false
+func parseSource(spec string) (*source, bool, error) {
+	spec = strings.TrimSpace(spec)
+
+	format := ""
+	rest := spec
+	if name, remain, ok := strings.Cut(spec, ":"); ok {
+		if _, registered := lookupFormat(name); registered {
+			format, rest = strings.ToLower(name), remain
+		}
+	}
+
+	kind, location, ok := strings.Cut(rest, ":")
+	if !ok || (kind != sourceFile && kind != sourceURL) {
+		if format != "" {
+			return nil, true, fmt.Errorf("外部规则源 %s 缺少 file: 或 url: 前缀", spec)
+		}
+		return nil, false, nil
+	}
+
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return nil, true, fmt.Errorf("外部规则源 %s 缺少路径", spec)
+	}
+	if format == "" {
+		format = formatByExtension(location)
+	}
+
+	return &source{spec: spec, format: format, kind: kind, location: location}, true, nil
+}
+
+// formatByExtension 按扩展名推断格式
+func formatByExtension(location string) string {
+	// 去掉 URL 查询参数
+	if i := strings.IndexAny(location, "?#"); i >= 0 {
+		location = location[:i]
+	}
+
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".srs":
+		return "srs"
+	case ".yaml", ".yml":
+		return "clash"
+	default:
+		return "list"
+	}
+}
+
+// Sources 外部规则源管理（url 源缓存到本地目录，重启后直接使用缓存）
+type Sources struct {
+	dir      string
+	outbound utils.Outbound
+}
+
+// NewSources 创建外部规则源管理
+// dir 为 url 源的本地缓存目录；outbound 为 nil 时直连下载
+func NewSources(dir string, outbound utils.Outbound) *Sources {
+	return &Sources{
+		dir:      dir,
+		outbound: outbound,
+	}
+}
+
+// load 读取并解析规则源，url 源没有本地缓存时先下载
+func (s *Sources) load(src *source) ([]*router.Domain, error) {
+	path := s.path(src)
+	if src.kind == sourceURL && !utils.FileExists(path) {
+		if err := s.refresh(src); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.parseFile(src, path)
+}
+
+// refresh 重新下载 url 源，解析校验通过后才替换本地缓存（file 源每次编译时重新读取，无需刷新）
+func (s *Sources) refresh(src *source) error {
+	if src.kind != sourceURL {
+		return nil
+	}
+
+	path := s.path(src)
+	tmpPath := path + ".new"
+	if err := utils.FetchRuleSetWithOutbound(src.location, tmpPath, s.outbound); err != nil {
+		return fmt.Errorf("下载规则源 %s 失败: %w", src.location, err)
+	}
+
+	if _, err := s.parseFile(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("替换规则源缓存失败: %w", err)
+	}
+	return nil
+}
+
+// parseFile 按规则源的格式解析文件
+func (s *Sources) parseFile(src *source, path string) ([]*router.Domain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取规则源 %s 失败: %w", src.spec, err)
+	}
+
+	parse, _ := lookupFormat(src.format)
+	domains, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析规则源 %s (%s 格式) 失败: %w", src.spec, src.format, err)
+	}
+	return domains, nil
+}
+
+// path 返回规则源的本地文件路径
+func (s *Sources) path(src *source) string {
+	if src.kind == sourceFile {
+		return src.location
+	}
+
+	sum := sha256.Sum256([]byte(src.location))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:8])+"."+src.format)
+}
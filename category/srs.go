@@ -0,0 +1,444 @@
+package category
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"violet-dns/component/geodata/router"
+)
+
+// sing-box 二进制规则集 (.srs)
+// 文件结构: "SRS" + 版本(1 字节) + zlib 压缩的规则列表
+// 只提取 domain、domain_suffix、domain_keyword、domain_regex，其余规则项（IP、端口、进程等）在 DNS 层无法表达
+
+// srsMagic 文件头
+var srsMagic = []byte("SRS")
+
+// srsMaxLength 单个字段的最大长度（防止损坏的文件导致超大内存分配）
+const srsMaxLength = 64 << 20
+
+// srsMaxDepth 逻辑规则的最大嵌套深度（防止损坏的文件导致栈溢出）
+const srsMaxDepth = 32
+
+// 规则项类型
+const (
+	srsItemQueryType           = 0
+	srsItemNetwork             = 1
+	srsItemDomain              = 2
+	srsItemDomainKeyword       = 3
+	srsItemDomainRegex         = 4
+	srsItemSourceIPCIDR        = 5
+	srsItemIPCIDR              = 6
+	srsItemSourcePort          = 7
+	srsItemSourcePortRange     = 8
+	srsItemPort                = 9
+	srsItemPortRange           = 10
+	srsItemProcessName         = 11
+	srsItemProcessPath         = 12
+	srsItemPackageName         = 13
+	srsItemWIFISSID            = 14
+	srsItemWIFIBSSID           = 15
+	srsItemAdGuardDomain       = 16
+	srsItemProcessPathRegex    = 17
+	srsItemNetworkType         = 18
+	srsItemNetworkIsExpensive  = 19
+	srsItemNetworkIsConstraint = 20
+	srsItemFinal               = 0xFF
+)
+
+// 域名后缀树中的特殊标签
+const (
+	srsPrefixLabel = '\r' // 仅匹配子域名（domain_suffix 以 . 开头）
+	srsRootLabel   = '\n' // 匹配域名及子域名
+)
+
+// srsRule 解析出的规则
+type srsRule struct {
+	domains []*router.Domain
+	usable  bool // 规则只包含域名条件且未取反
+}
+
+// parseSRSFormat 解析 sing-box 二进制规则集
+func parseSRSFormat(data []byte) ([]*router.Domain, error) {
+	if len(data) < len(srsMagic)+1 || !bytes.Equal(data[:len(srsMagic)], srsMagic) {
+		return nil, fmt.Errorf("不是 sing-box 二进制规则集")
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[len(srsMagic)+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("解压失败: %w", err)
+	}
+	defer zr.Close()
+	reader := bufio.NewReader(zr)
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取规则数量失败: %w", err)
+	}
+
+	var domains []*router.Domain
+	for i := uint64(0); i < count; i++ {
+		rule, err := readSRSRule(reader, 0)
+		if err != nil {
+			return nil, fmt.Errorf("读取第 %d 条规则失败: %w", i, err)
+		}
+		if rule.usable {
+			domains = append(domains, rule.domains...)
+		}
+	}
+
+	// 读到结尾以校验 zlib 校验和，截断的文件返回错误
+	if _, err := reader.ReadByte(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("规则之后有多余的数据")
+		}
+		return nil, fmt.Errorf("规则集数据损坏: %w", err)
+	}
+	return domains, nil
+}
+
+// readSRSRule 读取一条规则（0: 普通规则，1: 逻辑规则），depth 为逻辑规则嵌套深度
+func readSRSRule(reader *bufio.Reader, depth int) (*srsRule, error) {
+	ruleType, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch ruleType {
+	case 0:
+		return readSRSDefaultRule(reader)
+	case 1:
+		if depth >= srsMaxDepth {
+			return nil, fmt.Errorf("逻辑规则嵌套超过 %d 层", srsMaxDepth)
+		}
+		return readSRSLogicalRule(reader, depth+1)
+	default:
+		return nil, fmt.Errorf("未知规则类型 %d", ruleType)
+	}
+}
+
+// readSRSDefaultRule 读取普通规则
+func readSRSDefaultRule(reader *bufio.Reader) (*srsRule, error) {
+	rule := &srsRule{usable: true}
+
+	for {
+		itemType, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch itemType {
+		case srsItemDomain:
+			domains, err := readSRSDomainMatcher(reader)
+			if err != nil {
+				return nil, err
+			}
+			rule.domains = append(rule.domains, domains...)
+		case srsItemDomainKeyword, srsItemDomainRegex:
+			values, err := readSRSStrings(reader)
+			if err != nil {
+				return nil, err
+			}
+			domainType := router.Domain_Plain
+			if itemType == srsItemDomainRegex {
+				domainType = router.Domain_Regex
+			}
+			for _, value := range values {
+				rule.domains = append(rule.domains, newDomain(domainType, value))
+			}
+		case srsItemNetwork, srsItemSourcePortRange, srsItemPortRange, srsItemProcessName, srsItemProcessPath,
+			srsItemPackageName, srsItemWIFISSID, srsItemWIFIBSSID, srsItemProcessPathRegex:
+			if _, err := readSRSStrings(reader); err != nil {
+				return nil, err
+			}
+			rule.usable = false
+		case srsItemQueryType, srsItemSourcePort, srsItemPort:
+			if err := skipSRSUint16s(reader); err != nil {
+				return nil, err
+			}
+			rule.usable = false
+		case srsItemSourceIPCIDR, srsItemIPCIDR:
+			if err := skipSRSIPSet(reader); err != nil {
+				return nil, err
+			}
+			rule.usable = false
+		case srsItemNetworkType:
+			if _, err := readSRSBytes(reader); err != nil {
+				return nil, err
+			}
+			rule.usable = false
+		case srsItemNetworkIsExpensive, srsItemNetworkIsConstraint:
+			rule.usable = false
+		case srsItemFinal:
+			invert, err := reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if invert != 0 {
+				rule.usable = false
+			}
+			return rule, nil
+		case srsItemAdGuardDomain:
+			return nil, fmt.Errorf("不支持 adguard_domain 规则项，请使用 adblock 格式的源文件")
+		default:
+			return nil, fmt.Errorf("未知规则项类型 %d", itemType)
+		}
+	}
+}
+
+// readSRSLogicalRule 读取逻辑规则（仅未取反的 or 规则可以展开为域名列表）
+func readSRSLogicalRule(reader *bufio.Reader, depth int) (*srsRule, error) {
+	mode, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &srsRule{usable: mode == 1}
+	for i := uint64(0); i < count; i++ {
+		sub, err := readSRSRule(reader, depth)
+		if err != nil {
+			return nil, err
+		}
+		if !sub.usable {
+			rule.usable = false
+		}
+		rule.domains = append(rule.domains, sub.domains...)
+	}
+
+	invert, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if invert != 0 {
+		rule.usable = false
+	}
+	return rule, nil
+}
+
+// readSRSDomainMatcher 读取域名后缀树（succinct trie，键为反转后的域名）
+// 并还原为 domain（完整匹配）和 domain_suffix（后缀匹配）规则
+func readSRSDomainMatcher(reader *bufio.Reader) ([]*router.Domain, error) {
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("未知的域名匹配器版本 %d", version)
+	}
+
+	leaves, err := readSRSUint64s(reader)
+	if err != nil {
+		return nil, err
+	}
+	labelBitmap, err := readSRSUint64s(reader)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := readSRSBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := succinctKeys(leaves, labelBitmap, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	full := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	var domains []*router.Domain
+	for _, key := range keys {
+		domain := reverseString(key)
+		switch domain[0] {
+		case srsRootLabel:
+			domains = append(domains, newDomain(router.Domain_Domain, domain[1:]))
+		case srsPrefixLabel:
+			prefixes[domain[1:]] = true
+		default:
+			full[domain] = true
+		}
+	}
+
+	// 旧版本规则集将 domain_suffix "google.com" 存为 "google.com" + "\r.google.com"
+	for prefix := range prefixes {
+		root := strings.TrimPrefix(prefix, ".")
+		if root != prefix && full[root] {
+			delete(full, root)
+			domains = append(domains, newDomain(router.Domain_Domain, root))
+			continue
+		}
+		domains = append(domains, newDomain(router.Domain_Regex, regexp.QuoteMeta(prefix)+"$"))
+	}
+	for domain := range full {
+		domains = append(domains, newDomain(router.Domain_Full, domain))
+	}
+
+	return domains, nil
+}
+
+// succinctKeys 遍历 succinct trie 的全部键
+// labelBitmap 按 BFS 顺序为每个节点记录若干个 0（每个子节点一个）和一个结束标记 1，
+// 第 k 个 0 对应 labels[k]，指向编号为 k+1 的子节点；leaves 标记节点是否为键的结尾
+func succinctKeys(leaves, labelBitmap []uint64, labels []byte) ([]string, error) {
+	type edge struct {
+		label byte
+		child int
+	}
+	children := make(map[int][]edge)
+
+	node, labelIndex := 0, 0
+	for i := 0; i < len(labelBitmap)*64; i++ {
+		if labelBitmap[i>>6]&(1<<uint(i&63)) != 0 {
+			node++
+			continue
+		}
+		if labelIndex >= len(labels) {
+			break
+		}
+		children[node] = append(children[node], edge{label: labels[labelIndex], child: labelIndex + 1})
+		labelIndex++
+	}
+	if labelIndex != len(labels) {
+		return nil, fmt.Errorf("域名匹配器数据损坏")
+	}
+
+	isLeaf := func(node int) bool {
+		return node>>6 < len(leaves) && leaves[node>>6]&(1<<uint(node&63)) != 0
+	}
+
+	var keys []string
+	var walk func(node int, key []byte)
+	walk = func(node int, key []byte) {
+		if isLeaf(node) && len(key) > 0 {
+			keys = append(keys, string(key))
+		}
+		for _, e := range children[node] {
+			walk(e.child, append(key, e.label))
+		}
+	}
+	walk(0, nil)
+
+	return keys, nil
+}
+
+// reverseString 按字节反转字符串（域名为 ASCII）
+func reverseString(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// readSRSBytes 读取 uvarint 长度前缀的字节串
+func readSRSBytes(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > srsMaxLength {
+		return nil, fmt.Errorf("长度 %d 超出范围", length)
+	}
+	// 按实际读到的数据分配内存，损坏的长度字段不会导致超大分配
+	buf, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(buf)) != length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+// readSRSStrings 读取字符串列表
+func readSRSStrings(reader *bufio.Reader) ([]string, error) {
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > srsMaxLength {
+		return nil, fmt.Errorf("长度 %d 超出范围", count)
+	}
+
+	values := make([]string, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		value, err := readSRSBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, string(value))
+	}
+	return values, nil
+}
+
+// readSRSUint64s 读取大端序 uint64 列表
+func readSRSUint64s(reader *bufio.Reader) ([]uint64, error) {
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > srsMaxLength/8 {
+		return nil, fmt.Errorf("长度 %d 超出范围", count)
+	}
+
+	values := make([]uint64, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		var value uint64
+		if err := binary.Read(reader, binary.BigEndian, &value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// skipSRSUint16s 跳过大端序 uint16 列表
+func skipSRSUint16s(reader *bufio.Reader) error {
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return err
+	}
+	if count > srsMaxLength/2 {
+		return fmt.Errorf("长度 %d 超出范围", count)
+	}
+	_, err = reader.Discard(int(count) * 2)
+	return err
+}
+
+// skipSRSIPSet 跳过 IP 集合（版本 + uint64 区间数量 + 每个区间的起止地址）
+func skipSRSIPSet(reader *bufio.Reader) error {
+	version, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != 1 {
+		return fmt.Errorf("未知的 IP 集合版本 %d", version)
+	}
+
+	var count uint64
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	if count > srsMaxLength {
+		return fmt.Errorf("长度 %d 超出范围", count)
+	}
+	for i := uint64(0); i < count*2; i++ {
+		if _, err := readSRSBytes(reader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package category
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+// testdata/rules.srs 和 testdata/legacy.srs 是同目录下 rules.json、legacy.json 的二进制规则集
+// （legacy.srs 为版本 1 格式，domain_suffix 按旧方式存为完整域名 + "\r." 前缀）
+
+// readSRSFixture 读取测试规则集
+func readSRSFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// srsPayload 解压规则集，返回版本和规则数据
+func srsPayload(t *testing.T, data []byte) (byte, []byte) {
+	t.Helper()
+	zr, err := zlib.NewReader(bytes.NewReader(data[len(srsMagic)+1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[len(srsMagic)], payload
+}
+
+// srsFile 将规则数据压缩为规则集文件
+func srsFile(version byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(srsMagic)
+	buf.WriteByte(version)
+	zw := zlib.NewWriter(&buf)
+	zw.Write(payload)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestParseSRSFormat(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{
+			// 含 IP、端口条件、取反和 and 逻辑规则的条目无法在 DNS 层表达，整条跳过
+			file: "rules.srs",
+			want: []string{
+				"full:example.com",
+				"domain:google.com",
+				`regexp:\.cdn\.example\.net$`,
+				"keyword:tracker",
+				`regexp:^ads\d+\.example\.org$`,
+				"full:or-a.example",
+				"full:or-b.example",
+			},
+		},
+		{
+			file: "legacy.srs",
+			want: []string{
+				"full:example.com",
+				"domain:google.com",
+				`regexp:\.cdn\.example\.net$`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		domains, err := parseSRSFormat(readSRSFixture(t, tt.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		expectDomains(t, tt.file, domains, tt.want)
+	}
+}
+
+func TestParseSRSFormatTruncated(t *testing.T) {
+	data := readSRSFixture(t, "rules.srs")
+	version, payload := srsPayload(t, data)
+
+	// 文件在任意位置截断（包括只缺少 zlib 校验和）都返回错误
+	for n := 0; n < len(data); n++ {
+		if _, err := parseSRSFormat(data[:n]); err == nil {
+			t.Errorf("文件截断到 %d/%d 字节时应返回错误", n, len(data))
+		}
+	}
+
+	// 规则数据在任意位置截断都返回错误
+	for n := 0; n < len(payload); n++ {
+		if _, err := parseSRSFormat(srsFile(version, payload[:n])); err == nil {
+			t.Errorf("规则数据截断到 %d/%d 字节时应返回错误", n, len(payload))
+		}
+	}
+
+	// 规则之后有多余数据
+	if _, err := parseSRSFormat(srsFile(version, append(payload, 0))); err == nil {
+		t.Error("规则之后有多余数据时应返回错误")
+	}
+}
+
+func TestParseSRSFormatCorrupt(t *testing.T) {
+	version, payload := srsPayload(t, readSRSFixture(t, "rules.srs"))
+
+	// 任意字节损坏都只能返回错误或解析结果，不能 panic
+	corrupt := make([]byte, len(payload))
+	for i := range payload {
+		for _, b := range []byte{0x00, 0x01, 0x7f, 0x80, 0xff} {
+			copy(corrupt, payload)
+			corrupt[i] = b
+			parseSRSFormat(srsFile(version, corrupt))
+		}
+	}
+
+	uvarint := func(v uint64) []byte { return binary.AppendUvarint(nil, v) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	u64s := func(values ...uint64) []byte {
+		buf := uvarint(uint64(len(values)))
+		for _, v := range values {
+			buf = binary.BigEndian.AppendUint64(buf, v)
+		}
+		return buf
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"空文件", nil},
+		{"文件头错误", []byte("SRT\x01")},
+		{"只有文件头", []byte("SRS")},
+		{"数据不是 zlib", []byte("SRS\x02not zlib")},
+		{"未知规则类型", srsFile(2, cat(uvarint(1), []byte{9}))},
+		{"未知规则项", srsFile(2, cat(uvarint(1), []byte{0, 0x42}))},
+		{"字符串列表长度超出范围", srsFile(2, cat(uvarint(1), []byte{0, srsItemDomainKeyword}, uvarint(1<<40)))},
+		{"字符串列表长度大于实际数据", srsFile(2, cat(uvarint(1), []byte{0, srsItemDomainKeyword}, uvarint(1<<20)))},
+		{"字节串长度大于实际数据", srsFile(2, cat(uvarint(1), []byte{0, srsItemDomainKeyword}, uvarint(1), uvarint(srsMaxLength)))},
+		{"域名匹配器标签多于位图", srsFile(2, cat(uvarint(1), []byte{0, srsItemDomain, 1}, u64s(0b10), u64s(^uint64(1)), uvarint(3), []byte{'a', 'b', 'c', srsItemFinal, 0}))},
+		{"逻辑规则嵌套过深", srsFile(2, cat(uvarint(1), bytes.Repeat([]byte{1, 1, 1}, srsMaxDepth+1)))},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseSRSFormat(tt.data); err == nil {
+			t.Errorf("%s: 期望返回错误", tt.name)
+		}
+	}
+}
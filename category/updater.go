@@ -4,44 +4,89 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"violet-dns/config"
+	"violet-dns/utils"
 
 	"github.com/robfig/cron/v3"
 )
 
-// Updater 定时更新器
+// Updater 定时更新器（重新下载 DLC 文件和外部规则源，校验后按差异替换规则集）
 type Updater struct {
+	mu          sync.Mutex // 各定时任务串行执行编译和替换
 	loader      *Loader
 	cron        *cron.Cron
 	cronExpr    string
+	url         string
 	filename    string
-	groupConfig map[string][]string
+	groupConfig config.DomainGroups
+	outbound    utils.Outbound
 }
 
 // NewUpdater 创建新的更新器
-func NewUpdater(loader *Loader, cronExpr, filename string, groupConfig map[string][]string) *Updater {
+// url 为空时仅重新读取本地文件；outbound 为 nil 时直连下载
+func NewUpdater(loader *Loader, cronExpr, url, filename string, groupConfig config.DomainGroups, outbound utils.Outbound) *Updater {
 	return &Updater{
 		loader:      loader,
-		cron:        cron.New(cron.WithSeconds()), // 支持秒字段 (6 个字段格式)
+		cron:        cron.New(cron.WithSeconds(), cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger))), // 支持秒字段 (6 个字段格式)，上一次更新未完成时跳过
 		cronExpr:    cronExpr,
+		url:         url,
 		filename:    filename,
 		groupConfig: groupConfig,
+		outbound:    outbound,
 	}
 }
 
 // Start 启动定时更新
+// DLC 文件按 cronExpr 更新，配置了 update 的外部规则源按各自的 cron 表达式更新
 func (u *Updater) Start(ctx context.Context) error {
-	if u.cronExpr == "" {
-		return nil // 未配置更新，跳过
+	jobs := 0
+
+	// 添加 DLC 文件定时任务
+	if u.cronExpr != "" {
+		_, err := u.cron.AddFunc(u.cronExpr, func() {
+			if err := u.Update(); err != nil {
+				log.Printf("更新域名分类失败: %v\n", err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("添加定时任务失败: %w", err)
+		}
+		jobs++
 	}
 
-	// 添加定时任务
-	_, err := u.cron.AddFunc(u.cronExpr, func() {
-		if err := u.loader.Load(u.filename, u.groupConfig); err != nil {
-			log.Printf("更新域名分类失败: %v\n", err)
+	// 添加外部规则源定时任务
+	for _, group := range u.groupConfig {
+		for _, entry := range group.Entries {
+			if entry.Update == "" {
+				continue
+			}
+
+			src, isSource, err := parseSource(entry.Source)
+			if err != nil {
+				return err
+			}
+			if !isSource {
+				return fmt.Errorf("%s 不是外部规则源，不支持单独配置 update", entry.Source)
+			}
+
+			_, err = u.cron.AddFunc(entry.Update, func() {
+				if err := u.updateSource(src); err != nil {
+					log.Printf("更新规则源 %s 失败: %v\n", src.spec, err)
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("添加规则源 %s 定时任务失败: %w", entry.Source, err)
+			}
+			jobs++
 		}
-	})
-	if err != nil {
-		return fmt.Errorf("添加定时任务失败: %w", err)
+	}
+
+	if jobs == 0 {
+		return nil // 未配置更新，跳过
 	}
 
 	// 启动 cron
@@ -62,3 +107,123 @@ func (u *Updater) Stop() {
 		u.cron.Stop()
 	}
 }
+
+// Update 执行一次更新
+// 新文件先下载到临时路径并完整编译，成功后才替换本地文件和规则集，失败时保留当前规则
+func (u *Updater) Update() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	filename := u.filename
+	if u.url != "" {
+		filename = u.filename + ".new"
+		if err := utils.FetchFileWithOutbound(u.url, filename, u.outbound); err != nil {
+			return fmt.Errorf("下载 %s 失败: %w", u.url, err)
+		}
+	}
+
+	// 编译同时校验 protobuf 和域名组配置
+	rules, err := u.loader.build(filename, u.groupConfig)
+	if err != nil {
+		if filename != u.filename {
+			os.Remove(filename)
+		}
+		return err
+	}
+
+	if filename != u.filename {
+		if err := os.Rename(filename, u.filename); err != nil {
+			os.Remove(filename)
+			return fmt.Errorf("替换 %s 失败: %w", u.filename, err)
+		}
+	}
+
+	u.apply("dlc.dat", rules)
+	return nil
+}
+
+// updateSource 重新下载单个外部规则源并重新编译规则集
+func (u *Updater) updateSource(src *source) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.loader.sources.refresh(src); err != nil {
+		return err
+	}
+
+	rules, err := u.loader.build(u.filename, u.groupConfig)
+	if err != nil {
+		return err
+	}
+
+	u.apply(src.spec, rules)
+	return nil
+}
+
+// apply 替换规则集并输出差异
+func (u *Updater) apply(trigger string, rules *domainRules) {
+	diff := diffRules(u.loader.domains.rules.Load(), rules)
+	generation := u.loader.domains.replace(rules)
+
+	logDiff(trigger, generation, diff)
+}
+
+// ruleDiff 两次加载之间的规则差异（键为 "类型:值"）
+type ruleDiff struct {
+	added   []string
+	removed []string
+	changed []string // 格式: "类型:值 (旧组 -> 新组)"
+}
+
+// diffRules 比较新旧规则
+func diffRules(oldRules, newRules *domainRules) ruleDiff {
+	oldEntries := oldRules.entries()
+	newEntries := newRules.entries()
+
+	var diff ruleDiff
+	for rule, group := range newEntries {
+		oldGroup, exists := oldEntries[rule]
+		if !exists {
+			diff.added = append(diff.added, rule)
+		} else if oldGroup != group {
+			diff.changed = append(diff.changed, fmt.Sprintf("%s (%s -> %s)", rule, oldGroup, group))
+		}
+	}
+	for rule := range oldEntries {
+		if _, exists := newEntries[rule]; !exists {
+			diff.removed = append(diff.removed, rule)
+		}
+	}
+
+	sort.Strings(diff.added)
+	sort.Strings(diff.removed)
+	sort.Strings(diff.changed)
+	return diff
+}
+
+// maxDiffExamples 每类差异在日志中列出的规则示例数量
+const maxDiffExamples = 5
+
+// logDiff 将更新摘要写入日志
+func logDiff(trigger string, generation uint64, diff ruleDiff) {
+	log.Printf("域名分类更新完成 (%s, 第 %d 代): 新增 %d, 删除 %d, 变更 %d\n",
+		trigger, generation, len(diff.added), len(diff.removed), len(diff.changed))
+
+	for _, item := range []struct {
+		name  string
+		rules []string
+	}{
+		{"新增", diff.added},
+		{"删除", diff.removed},
+		{"变更", diff.changed},
+	} {
+		if len(item.rules) == 0 {
+			continue
+		}
+		examples := item.rules
+		if len(examples) > maxDiffExamples {
+			examples = examples[:maxDiffExamples]
+		}
+		log.Printf("  %s: %v\n", item.name, examples)
+	}
+}
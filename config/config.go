@@ -11,15 +11,51 @@ type Config struct {
 	Redis          RedisConfig                     `yaml:"redis"`
 	CategoryPolicy CategoryPolicyConfig            `yaml:"category_policy"`
 	QueryPolicy    []QueryPolicyConfig             `yaml:"query_policy"`
+	RaceGroup      map[string]*RaceGroupConfig     `yaml:"race_group"`
 	Fallback       FallbackConfig                  `yaml:"fallback"`
 	Log            LogConfig                       `yaml:"log"`
+
+	raceGroupInjected bool // RaceGroup[DefaultRaceGroup] 由 applyDefaults 注入（配置中未声明）
 }
 
 // ServerConfig DNS 服务器配置
 type ServerConfig struct {
-	Port     int    `yaml:"port"`
-	Protocol string `yaml:"protocol"` // udp, tcp, both
-	Bind     string `yaml:"bind"`
+	Port           int       `yaml:"port"`
+	Protocol       string    `yaml:"protocol"` // udp, tcp, both
+	Bind           string    `yaml:"bind"`
+	TCPIdleTimeout int       `yaml:"tcp_idle_timeout"` // TCP 连接空闲超时（秒），默认 10
+	TLS            TLSConfig `yaml:"tls"`              // 加密监听器共用的证书配置
+	DoT            DoTConfig `yaml:"dot"`
+	DoH            DoHConfig `yaml:"doh"`
+	DoQ            DoQConfig `yaml:"doq"`
+}
+
+// TLSConfig 证书配置（文件变更后自动重新加载）
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// DoHConfig DNS-over-HTTPS 监听配置
+type DoHConfig struct {
+	Enable         bool     `yaml:"enable"`
+	Port           int      `yaml:"port"`            // 默认 443
+	Path           string   `yaml:"path"`            // 默认 /dns-query
+	Insecure       bool     `yaml:"insecure"`        // 使用明文 HTTP（部署在反向代理之后时使用）
+	TrustedProxies []string `yaml:"trusted_proxies"` // 信任其 X-Forwarded-For 的代理 CIDR
+}
+
+// DoQConfig DNS-over-QUIC 监听配置
+type DoQConfig struct {
+	Enable    bool `yaml:"enable"`
+	Port      int  `yaml:"port"`       // 默认 853（UDP）
+	Allow0RTT bool `yaml:"allow_0rtt"` // 接受 0-RTT 查询（可被重放），默认关闭
+}
+
+// DoTConfig DNS-over-TLS 监听配置
+type DoTConfig struct {
+	Enable bool `yaml:"enable"`
+	Port   int  `yaml:"port"` // 默认 853
 }
 
 // BootstrapConfig Bootstrap DNS 配置
@@ -31,8 +67,9 @@ type BootstrapConfig struct {
 type UpstreamGroupConfig struct {
 	Nameservers        []string `yaml:"nameservers"`
 	Outbound           string   `yaml:"outbound"`
-	ECSIP              string   `yaml:"ecs_ip"`              // 有值则添加 ECS，否则不添加
-	ResolveNameservers []string `yaml:"resolve_nameservers"` // 用于解析 nameservers 中的域名
+	ECSIP              string   `yaml:"ecs_ip"`              // IP/CIDR, client 或 none，空则使用全局 ECS 配置
+	ResolveMode        string   `yaml:"resolve_mode"`        // local 或 remote，nameservers 中的域名在本地还是由代理服务器解析（仅代理出站，未配置 resolve_nameservers/resolve_strategy 时默认 remote）
+	ResolveNameservers []string `yaml:"resolve_nameservers"` // 用于解析 nameservers 中的域名，空则使用 bootstrap
 	ResolveStrategy    string   `yaml:"resolve_strategy"`    // ipv4_only, ipv6_only, prefer_ipv4, prefer_ipv6
 }
 
@@ -49,11 +86,14 @@ type OutboundConfig struct {
 
 // ECSConfig ECS 配置
 type ECSConfig struct {
-	Enable      bool   `yaml:"enable"`
-	DefaultIPv4 string `yaml:"default_ipv4"`
-	DefaultIPv6 string `yaml:"default_ipv6"`
-	IPv4Prefix  int    `yaml:"ipv4_prefix"`
-	IPv6Prefix  int    `yaml:"ipv6_prefix"`
+	Enable              bool   `yaml:"enable"`
+	Mode                string `yaml:"mode"` // static（默认，使用 default_ipv4/default_ipv6）, client（使用客户端地址）
+	DefaultIPv4         string `yaml:"default_ipv4"`
+	DefaultIPv6         string `yaml:"default_ipv6"`
+	IPv4Prefix          int    `yaml:"ipv4_prefix"`
+	IPv6Prefix          int    `yaml:"ipv6_prefix"`
+	PrivateFallbackIPv4 string `yaml:"private_fallback_ipv4"` // client 模式下内网客户端使用的公网地址
+	PrivateFallbackIPv6 string `yaml:"private_fallback_ipv6"`
 }
 
 // CacheConfig 缓存配置
@@ -72,8 +112,8 @@ type DNSCacheConfig struct {
 // CategoryCacheConfig 分类缓存配置
 type CategoryCacheConfig struct {
 	Enable bool   `yaml:"enable"`
-	Clear  bool   `yaml:"clear"`
-	Type   string `yaml:"type"` // redis, memory
+	Clear  bool   `yaml:"clear"` // -load 时清除旧版本写入 Redis 的预加载分类（保留学习到的分类）
+	Type   string `yaml:"type"`  // redis, memory
 	TTL    int    `yaml:"ttl"`
 }
 
@@ -94,9 +134,9 @@ type CategoryPolicyConfig struct {
 
 // PreloadConfig 预加载配置
 type PreloadConfig struct {
-	File        string              `yaml:"file"`
-	Update      string              `yaml:"update"` // cron 表达式
-	DomainGroup map[string][]string `yaml:"domain_group"`
+	File        string       `yaml:"file"`
+	Update      string       `yaml:"update"`       // cron 表达式
+	DomainGroup DomainGroups `yaml:"domain_group"` // 按配置文件中的顺序排列，靠前的组优先
 }
 
 // QueryPolicyConfig 查询策略配置
@@ -112,12 +152,24 @@ type QueryPolicyOptions struct {
 	DisableCache   bool     `yaml:"disable_cache"`
 	DisableIPv6    bool     `yaml:"disable_ipv6"`
 	DisableHTTPS   bool     `yaml:"disable_https"`
-	ECS            string   `yaml:"ecs"`
+	ECS            string   `yaml:"ecs"` // IP/CIDR, client 或 none，覆盖上游组的 ECS 配置
 	ExpectedIPs    []string `yaml:"expected_ips"`
 	FallbackGroup  string   `yaml:"fallback_group"`
-	BlockType      string   `yaml:"block_type"` // nxdomain, noerror, 0.0.0.0
-	BlockTTL       int      `yaml:"block_ttl"`  // Block record TTL in seconds
-	AutoCategorize bool     `yaml:"auto_categorize"`
+	BlockType      string   `yaml:"block_type"`      // nxdomain, noerror, 0.0.0.0
+	BlockTTL       int      `yaml:"block_ttl"`       // Block record TTL in seconds
+	AutoCategorize bool     `yaml:"auto_categorize"` // 根据 race 组的判断结果学习域名分类（写入分类缓存）
+}
+
+// RaceGroupConfig 并发查询并按结果 IP 分类的组（可被 query_policy.group 引用）
+type RaceGroupConfig struct {
+	Primary       string   `yaml:"primary"`        // 并发查询，结果 IP 用于匹配 rule（如 proxy_ecs）
+	Secondary     string   `yaml:"secondary"`      // 并发查询，未匹配 rule 时优先使用其结果（如 proxy），可为空
+	Fallback      string   `yaml:"fallback"`       // primary 结果匹配 rule 时改用的组（如 direct）
+	Strategy      string   `yaml:"strategy"`       // race, sequential, direct_first，为空时使用 fallback.strategy
+	Rule          []string `yaml:"rule"`           // IP 规则，为空时使用 fallback.rule
+	Timeout       int      `yaml:"timeout"`        // 并发查询超时（秒），默认 3
+	MatchCategory string   `yaml:"match_category"` // 匹配 rule 时写入分类缓存的分类（如 direct_site），空则不写入
+	MissCategory  string   `yaml:"miss_category"`  // 未匹配 rule 时写入分类缓存的分类（如 proxy_site），空则不写入
 }
 
 // FallbackConfig 回退配置
@@ -125,8 +177,10 @@ type FallbackConfig struct {
 	GeoIP    string   `yaml:"geoip"`
 	ASN      string   `yaml:"asn"`
 	Update   string   `yaml:"update"`   // cron 表达式
-	Strategy string   `yaml:"strategy"` // race
+	Strategy string   `yaml:"strategy"` // race（默认）, sequential, direct_first
 	Rule     []string `yaml:"rule"`
+
+	AutoCategorize bool `yaml:"auto_categorize"` // 默认策略（未配置 unknown 策略、IP 验证失败回退）是否学习域名分类，默认 false
 }
 
 // LogConfig 日志配置
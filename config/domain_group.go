@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DomainGroup 域名组：组名及其引用的分类和外部规则源
+type DomainGroup struct {
+	Name    string
+	Entries []DomainGroupEntry
+}
+
+// DomainGroupEntry 域名组条目
+// 可以直接写字符串（DLC 分类或外部规则源），也可以写成映射为外部规则源单独指定更新时间：
+//
+//   - google
+//   - clash:url:https://example.com/proxy.yaml
+//   - source: adblock:url:https://example.com/filter.txt
+//     update: "0 0 */6 * * *"
+type DomainGroupEntry struct {
+	Source string `yaml:"source"`
+	Update string `yaml:"update"` // cron 表达式（仅外部规则源）
+}
+
+// DomainGroups 有序的域名组列表
+// YAML 中仍以映射形式书写，解析时保留书写顺序（越靠前优先级越高）
+type DomainGroups []DomainGroup
+
+// UnmarshalYAML 按书写顺序解析 domain_group 映射
+func (g *DomainGroups) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("domain_group 必须是映射 (第 %d 行)", value.Line)
+	}
+
+	groups := make(DomainGroups, 0, len(value.Content)/2)
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		keyNode, valueNode := value.Content[i], value.Content[i+1]
+
+		var name string
+		if err := keyNode.Decode(&name); err != nil {
+			return fmt.Errorf("解析域名组名称失败 (第 %d 行): %w", keyNode.Line, err)
+		}
+		if seen[name] {
+			return fmt.Errorf("域名组 %s 重复定义 (第 %d 行)", name, keyNode.Line)
+		}
+		seen[name] = true
+
+		var entries []DomainGroupEntry
+		if err := valueNode.Decode(&entries); err != nil {
+			return fmt.Errorf("解析域名组 %s 失败 (第 %d 行): %w", name, valueNode.Line, err)
+		}
+
+		groups = append(groups, DomainGroup{Name: name, Entries: entries})
+	}
+
+	*g = groups
+	return nil
+}
+
+// UnmarshalYAML 支持字符串和映射两种写法
+func (e *DomainGroupEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&e.Source)
+	}
+
+	type plain DomainGroupEntry
+	return value.Decode((*plain)(e))
+}
+
+// Get 获取指定组的条目
+func (g DomainGroups) Get(name string) ([]DomainGroupEntry, bool) {
+	for _, group := range g {
+		if group.Name == name {
+			return group.Entries, true
+		}
+	}
+	return nil, false
+}
+
+// HasUpdates 是否有外部规则源单独配置了更新时间
+func (g DomainGroups) HasUpdates() bool {
+	for _, group := range g {
+		for _, entry := range group.Entries {
+			if entry.Update != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
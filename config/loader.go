@@ -19,9 +19,56 @@ func Load(filename string) (*Config, error) {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
 
+	applyDefaults(&cfg)
+
 	return &cfg, nil
 }
 
+// DefaultRaceGroup 内置的 race 组名称（未分类域名的默认策略）
+const DefaultRaceGroup = "proxy_ecs_fallback"
+
+// builtinRaceGroup 未声明 race_group.proxy_ecs_fallback 时注入的内置 race 组：
+// 并发查询 proxy_ecs 和 proxy，匹配规则时改用 direct
+var builtinRaceGroup = RaceGroupConfig{
+	Primary:       "proxy_ecs",
+	Secondary:     "proxy",
+	Fallback:      "direct",
+	MatchCategory: "direct_site",
+	MissCategory:  "proxy_site",
+}
+
+// applyDefaults 填充配置默认值
+func applyDefaults(cfg *Config) {
+	if cfg.RaceGroup == nil {
+		cfg.RaceGroup = make(map[string]*RaceGroupConfig)
+	}
+
+	// 未显式声明时注入内置的 proxy_ecs_fallback
+	if _, exists := cfg.RaceGroup[DefaultRaceGroup]; !exists {
+		group := builtinRaceGroup
+		cfg.RaceGroup[DefaultRaceGroup] = &group
+		cfg.raceGroupInjected = true
+	}
+
+	for _, group := range cfg.RaceGroup {
+		if group == nil {
+			continue
+		}
+		if len(group.Rule) == 0 {
+			group.Rule = cfg.Fallback.Rule
+		}
+		if group.Timeout == 0 {
+			group.Timeout = 3
+		}
+		if group.Strategy == "" {
+			group.Strategy = cfg.Fallback.Strategy
+		}
+		if group.Strategy == "" {
+			group.Strategy = "race"
+		}
+	}
+}
+
 // LoadAndValidate 加载并验证配置
 func LoadAndValidate(filename string) (*Config, error) {
 	cfg, err := Load(filename)
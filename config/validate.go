@@ -14,13 +14,18 @@ func Validate(cfg *Config) error {
 		return fmt.Errorf("server.port: %w", err)
 	}
 
+	// 验证 Server
+	if err := validateServer(&cfg.Server); err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+
 	// 验证 Bootstrap
 	if err := validateBootstrap(&cfg.Bootstrap); err != nil {
 		return fmt.Errorf("bootstrap: %w", err)
 	}
 
 	// 验证 Upstream Group
-	if err := validateUpstreamGroup(cfg.UpstreamGroup); err != nil {
+	if err := validateUpstreamGroup(cfg.UpstreamGroup, requiredUpstreamGroups(cfg)); err != nil {
 		return fmt.Errorf("upstream_group: %w", err)
 	}
 
@@ -44,8 +49,13 @@ func Validate(cfg *Config) error {
 		return fmt.Errorf("category_policy: %w", err)
 	}
 
+	// 验证 Race Group
+	if err := validateRaceGroup(cfg.RaceGroup, cfg.UpstreamGroup); err != nil {
+		return fmt.Errorf("race_group: %w", err)
+	}
+
 	// 验证 Query Policy
-	if err := validateQueryPolicy(cfg.QueryPolicy, cfg.CategoryPolicy.Preload.DomainGroup, cfg.UpstreamGroup); err != nil {
+	if err := validateQueryPolicy(cfg.QueryPolicy, cfg.CategoryPolicy.Preload.DomainGroup, cfg.UpstreamGroup, cfg.RaceGroup); err != nil {
 		return fmt.Errorf("query_policy: %w", err)
 	}
 
@@ -69,24 +79,148 @@ func validatePort(port int) error {
 	return nil
 }
 
+func validateServer(cfg *ServerConfig) error {
+	validProtocols := map[string]bool{"": true, "udp": true, "tcp": true, "both": true}
+	if !validProtocols[cfg.Protocol] {
+		return fmt.Errorf("protocol 必须是 udp, tcp 或 both，当前为: %s", cfg.Protocol)
+	}
+	if cfg.TCPIdleTimeout < 0 {
+		return fmt.Errorf("tcp_idle_timeout 不能为负数")
+	}
+
+	if cfg.DoT.Enable {
+		if cfg.DoT.Port != 0 {
+			if err := validatePort(cfg.DoT.Port); err != nil {
+				return fmt.Errorf("dot.port: %w", err)
+			}
+		}
+		if err := validateTLS(&cfg.TLS); err != nil {
+			return fmt.Errorf("dot: %w", err)
+		}
+	}
+
+	if cfg.DoH.Enable {
+		if cfg.DoH.Port != 0 {
+			if err := validatePort(cfg.DoH.Port); err != nil {
+				return fmt.Errorf("doh.port: %w", err)
+			}
+		}
+		if cfg.DoH.Path != "" && !strings.HasPrefix(cfg.DoH.Path, "/") {
+			return fmt.Errorf("doh.path 必须以 / 开头: %s", cfg.DoH.Path)
+		}
+		if !cfg.DoH.Insecure {
+			if err := validateTLS(&cfg.TLS); err != nil {
+				return fmt.Errorf("doh: %w", err)
+			}
+		}
+		for _, cidr := range cfg.DoH.TrustedProxies {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("doh.trusted_proxies 格式无效: %s", cidr)
+			}
+		}
+	}
+
+	if cfg.DoQ.Enable {
+		if cfg.DoQ.Port != 0 {
+			if err := validatePort(cfg.DoQ.Port); err != nil {
+				return fmt.Errorf("doq.port: %w", err)
+			}
+		}
+		if err := validateTLS(&cfg.TLS); err != nil {
+			return fmt.Errorf("doq: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateTLS(cfg *TLSConfig) error {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return fmt.Errorf("启用加密监听器时必须配置 tls.cert_file 和 tls.key_file")
+	}
+	return nil
+}
+
 func validateBootstrap(cfg *BootstrapConfig) error {
 	if len(cfg.Nameservers) == 0 {
 		return fmt.Errorf("至少需要配置一个 nameserver")
 	}
+	for _, ns := range cfg.Nameservers {
+		if err := validateBootstrapNameserver(ns); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func validateUpstreamGroup(groups map[string]*UpstreamGroupConfig) error {
-	// 必须有三个组
-	requiredGroups := []string{"proxy", "proxy_ecs", "direct"}
+// validateBootstrapNameserver bootstrap nameserver 自身无法被解析，必须是 IP 地址（可带 udp:// 或 tcp:// 前缀和端口）
+func validateBootstrapNameserver(ns string) error {
+	address := ns
+	if proto, rest, ok := strings.Cut(ns, "://"); ok {
+		if proto != "udp" && proto != "tcp" {
+			return fmt.Errorf("nameserver %s 仅支持 udp 和 tcp 协议", ns)
+		}
+		address = rest
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.Trim(address, "[]")
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("nameserver %s 必须是 IP 地址", ns)
+	}
+	return nil
+}
+
+// requiredUpstreamGroups 返回必须配置的上游组：使用内置 race 组时为其引用的组，自定义了 proxy_ecs_fallback 时没有
+func requiredUpstreamGroups(cfg *Config) []string {
+	if !cfg.raceGroupInjected {
+		return nil
+	}
+	return []string{builtinRaceGroup.Primary, builtinRaceGroup.Secondary, builtinRaceGroup.Fallback}
+}
+
+func validateUpstreamGroup(groups map[string]*UpstreamGroupConfig, requiredGroups []string) error {
 	for _, name := range requiredGroups {
-		group, exists := groups[name]
-		if !exists {
-			return fmt.Errorf("缺少必需的组: %s", name)
+		if _, exists := groups[name]; !exists {
+			return fmt.Errorf("缺少必需的组: %s（未声明 race_group.%s 时使用内置 race 组）", name, DefaultRaceGroup)
 		}
-		if len(group.Nameservers) == 0 {
+	}
+
+	// 验证 nameserver、ecs_ip 和域名解析配置
+	for name, group := range groups {
+		if group == nil || len(group.Nameservers) == 0 {
 			return fmt.Errorf("组 %s 至少需要一个 nameserver", name)
 		}
+
+		if err := validateECSAddress(group.ECSIP); err != nil {
+			return fmt.Errorf("组 %s ecs_ip: %w", name, err)
+		}
+
+		switch group.ResolveMode {
+		case "", "local":
+		case "remote":
+			if group.Outbound == "" || group.Outbound == "direct" {
+				return fmt.Errorf("组 %s resolve_mode: remote 仅适用于代理出站", name)
+			}
+			if len(group.ResolveNameservers) > 0 || group.ResolveStrategy != "" {
+				return fmt.Errorf("组 %s resolve_mode: remote 时不能配置 resolve_nameservers 和 resolve_strategy", name)
+			}
+		default:
+			return fmt.Errorf("组 %s resolve_mode 无效: %s (可选 local, remote)", name, group.ResolveMode)
+		}
+
+		for _, ns := range group.ResolveNameservers {
+			if err := validateBootstrapNameserver(ns); err != nil {
+				return fmt.Errorf("组 %s resolve_nameservers: %w", name, err)
+			}
+		}
+
+		switch group.ResolveStrategy {
+		case "", "ipv4_only", "ipv6_only", "prefer_ipv4", "prefer_ipv6":
+		default:
+			return fmt.Errorf("组 %s resolve_strategy 无效: %s (可选 ipv4_only, ipv6_only, prefer_ipv4, prefer_ipv6)", name, group.ResolveStrategy)
+		}
 	}
 	return nil
 }
@@ -117,14 +251,13 @@ func validateOutbound(outbounds []OutboundConfig, groups map[string]*UpstreamGro
 			return fmt.Errorf("组 %s 引用的 outbound 不存在: %s", name, group.Outbound)
 		}
 
-		// 验证非 direct outbound 的 nameserver 必须是 HTTPS
+		// 验证非 direct outbound 的 nameserver 协议可以经代理转发
 		if group.Outbound != "direct" && group.Outbound != "" {
 			outboundType := outboundTypes[group.Outbound]
 			if outboundType != "direct" {
-				// 检查所有 nameserver 是否都是 https://
 				for _, ns := range group.Nameservers {
-					if !strings.HasPrefix(ns, "https://") {
-						return fmt.Errorf("组 %s 使用非 direct outbound (%s)，nameserver 必须使用 HTTPS 协议，当前为: %s", name, group.Outbound, ns)
+					if err := validateProxyNameserver(ns); err != nil {
+						return fmt.Errorf("组 %s 使用非 direct outbound (%s): %w", name, group.Outbound, err)
 					}
 				}
 			}
@@ -133,23 +266,86 @@ func validateOutbound(outbounds []OutboundConfig, groups map[string]*UpstreamGro
 	return nil
 }
 
+// proxyNameserverProtocols 可以经代理出站转发的 nameserver 协议
+var proxyNameserverProtocols = map[string]bool{
+	"https": true,
+	"tls":   true,
+	"quic":  true,
+	"tcp":   true,
+	"udp":   true,
+}
+
+// validateProxyNameserver 验证经代理出站的 nameserver
+// 不带协议前缀的 host[:port] 为 UDP（经 UDP ASSOCIATE 转发，代理拒绝时改用 TCP）
+func validateProxyNameserver(ns string) error {
+	proto, address, ok := strings.Cut(ns, "://")
+	if !ok {
+		address = ns
+	} else if !proxyNameserverProtocols[proto] {
+		return fmt.Errorf("nameserver 仅支持 https://、tls://、quic://、tcp://、udp:// 协议或 host[:port]，当前为: %s", ns)
+	}
+
+	if strings.Trim(address, "/") == "" || strings.ContainsAny(address, " \t") {
+		return fmt.Errorf("nameserver 地址无效: %s", ns)
+	}
+	return nil
+}
+
 func validateECS(cfg *ECSConfig) error {
+	// 验证内网客户端回退地址（client 模式可在策略中单独启用，不受全局开关影响）
+	fallbacks := []struct {
+		name  string
+		value string
+		ipv4  bool
+	}{
+		{"private_fallback_ipv4", cfg.PrivateFallbackIPv4, true},
+		{"private_fallback_ipv6", cfg.PrivateFallbackIPv6, false},
+	}
+	for _, fb := range fallbacks {
+		if fb.value == "" {
+			continue
+		}
+		ip, err := parseECSAddress(fb.value)
+		if err != nil {
+			return fmt.Errorf("%s 格式无效: %w", fb.name, err)
+		}
+		if fb.ipv4 != (ip.To4() != nil) {
+			return fmt.Errorf("%s 地址族不匹配: %s", fb.name, fb.value)
+		}
+		if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			return fmt.Errorf("%s 必须是公网地址: %s", fb.name, fb.value)
+		}
+	}
+
 	if !cfg.Enable {
 		return nil
 	}
 
+	// 验证模式
+	if cfg.Mode != "" && cfg.Mode != "static" && cfg.Mode != "client" {
+		return fmt.Errorf("mode 必须是 static 或 client")
+	}
+
 	// 验证 IPv4
 	if cfg.DefaultIPv4 != "" {
-		if _, _, err := net.ParseCIDR(cfg.DefaultIPv4); err != nil {
+		ip, err := parseECSAddress(cfg.DefaultIPv4)
+		if err != nil {
 			return fmt.Errorf("default_ipv4 格式无效: %w", err)
 		}
+		if ip.To4() == nil {
+			return fmt.Errorf("default_ipv4 必须是 IPv4 地址")
+		}
 	}
 
 	// 验证 IPv6
 	if cfg.DefaultIPv6 != "" {
-		if _, _, err := net.ParseCIDR(cfg.DefaultIPv6); err != nil {
+		ip, err := parseECSAddress(cfg.DefaultIPv6)
+		if err != nil {
 			return fmt.Errorf("default_ipv6 格式无效: %w", err)
 		}
+		if ip.To4() != nil {
+			return fmt.Errorf("default_ipv6 必须是 IPv6 地址")
+		}
 	}
 
 	// 验证前缀长度
@@ -163,6 +359,26 @@ func validateECS(cfg *ECSConfig) error {
 	return nil
 }
 
+// validateECSAddress 验证 ECS 设置（空、none、client、IP 或 CIDR）
+func validateECSAddress(ecs string) error {
+	if ecs == "" || ecs == "none" || ecs == "client" {
+		return nil
+	}
+	_, err := parseECSAddress(ecs)
+	return err
+}
+
+// parseECSAddress 解析 ECS 地址（IP 或 CIDR）
+func parseECSAddress(ecs string) (net.IP, error) {
+	if ip, _, err := net.ParseCIDR(ecs); err == nil {
+		return ip, nil
+	}
+	if ip := net.ParseIP(ecs); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("必须是 IP 或 CIDR: %s", ecs)
+}
+
 func validateCache(cache *CacheConfig, redis *RedisConfig) error {
 	// 验证 DNS Cache
 	if cache.DNSCache.Enable {
@@ -192,22 +408,33 @@ func validateCategoryPolicy(cfg *CategoryPolicyConfig) error {
 		return fmt.Errorf("preload.file 必须配置")
 	}
 
+	for _, group := range cfg.Preload.DomainGroup {
+		for i, entry := range group.Entries {
+			if strings.TrimSpace(entry.Source) == "" {
+				return fmt.Errorf("preload.domain_group.%s[%d]: 不能为空", group.Name, i)
+			}
+		}
+	}
+
 	return nil
 }
 
-func validateQueryPolicy(policies []QueryPolicyConfig, domainGroups map[string][]string, groups map[string]*UpstreamGroupConfig) error {
+func validateQueryPolicy(policies []QueryPolicyConfig, domainGroups DomainGroups,
+	groups map[string]*UpstreamGroupConfig, raceGroups map[string]*RaceGroupConfig) error {
 	for i, policy := range policies {
 		// 验证名称匹配
 		if policy.Name != "unknown" {
-			if _, exists := domainGroups[policy.Name]; !exists {
+			if _, exists := domainGroups.Get(policy.Name); !exists {
 				return fmt.Errorf("策略 %d: 名称 %s 不存在于 domain_group 中", i, policy.Name)
 			}
 		}
 
 		// 验证 group 存在
-		if policy.Group != "block" && policy.Group != "proxy_ecs_fallback" {
-			if _, exists := groups[policy.Group]; !exists {
-				return fmt.Errorf("策略 %s: group %s 不存在于 upstream_group 中", policy.Name, policy.Group)
+		if policy.Group != "block" {
+			_, isUpstream := groups[policy.Group]
+			_, isRace := raceGroups[policy.Group]
+			if !isUpstream && !isRace {
+				return fmt.Errorf("策略 %s: group %s 不存在于 upstream_group 或 race_group 中", policy.Name, policy.Group)
 			}
 		}
 
@@ -219,6 +446,17 @@ func validateQueryPolicy(policies []QueryPolicyConfig, domainGroups map[string][
 			}
 		}
 
+		// 验证 strategy
+		validStrategies := map[string]bool{"": true, "ipv4_only": true, "ipv6_only": true, "prefer_ipv4": true, "prefer_ipv6": true}
+		if !validStrategies[policy.Options.Strategy] {
+			return fmt.Errorf("策略 %s: strategy 无效: %s", policy.Name, policy.Options.Strategy)
+		}
+
+		// 验证 ecs
+		if err := validateECSAddress(policy.Options.ECS); err != nil {
+			return fmt.Errorf("策略 %s: ecs 无效: %w", policy.Name, err)
+		}
+
 		// 验证 fallback_group 存在
 		if policy.Options.FallbackGroup != "" {
 			if _, exists := groups[policy.Options.FallbackGroup]; !exists {
@@ -247,20 +485,78 @@ func validateFallback(cfg *FallbackConfig) error {
 	if len(cfg.Rule) == 0 {
 		return fmt.Errorf("必须至少配置一条 rule")
 	}
+	if cfg.Strategy != "" && !validRaceStrategies[cfg.Strategy] {
+		return fmt.Errorf("strategy 必须是 race, sequential 或 direct_first")
+	}
 
 	// 验证 rule 格式
 	for _, rule := range cfg.Rule {
-		if !strings.HasPrefix(rule, "geoip:") && !strings.HasPrefix(rule, "asn:") {
-			return fmt.Errorf("rule 格式无效: %s", rule)
+		if err := validateIPRule(rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validRaceStrategies race 组支持的查询策略
+var validRaceStrategies = map[string]bool{"race": true, "sequential": true, "direct_first": true}
+
+func validateRaceGroup(raceGroups map[string]*RaceGroupConfig, groups map[string]*UpstreamGroupConfig) error {
+	for name, group := range raceGroups {
+		if group == nil {
+			return fmt.Errorf("组 %s 配置为空", name)
+		}
+		if name == "block" {
+			return fmt.Errorf("组名 block 为保留名称")
+		}
+		if _, exists := groups[name]; exists {
+			return fmt.Errorf("组 %s 与 upstream_group 重名", name)
 		}
-		if strings.HasPrefix(rule, "asn:") {
-			asnStr := strings.TrimPrefix(rule, "asn:")
-			if _, err := strconv.Atoi(asnStr); err != nil {
-				return fmt.Errorf("ASN 号码格式无效: %s", rule)
+
+		// 验证引用的上游组
+		if group.Primary == "" || group.Fallback == "" {
+			return fmt.Errorf("组 %s 必须配置 primary 和 fallback", name)
+		}
+		for _, ref := range []string{group.Primary, group.Secondary, group.Fallback} {
+			if ref == "" {
+				continue
+			}
+			if _, exists := groups[ref]; !exists {
+				return fmt.Errorf("组 %s 引用的上游组不存在: %s", name, ref)
+			}
+		}
+
+		if group.Timeout < 0 {
+			return fmt.Errorf("组 %s 的 timeout 不能为负数", name)
+		}
+		if !validRaceStrategies[group.Strategy] {
+			return fmt.Errorf("组 %s 的 strategy 必须是 race, sequential 或 direct_first", name)
+		}
+
+		if len(group.Rule) == 0 {
+			return fmt.Errorf("组 %s 必须至少配置一条 rule", name)
+		}
+		for _, rule := range group.Rule {
+			if err := validateIPRule(rule); err != nil {
+				return fmt.Errorf("组 %s: %w", name, err)
 			}
 		}
 	}
+	return nil
+}
 
+// validateIPRule 验证 IP 规则格式（geoip:xx / asn:xx）
+func validateIPRule(rule string) error {
+	if !strings.HasPrefix(rule, "geoip:") && !strings.HasPrefix(rule, "asn:") {
+		return fmt.Errorf("rule 格式无效: %s", rule)
+	}
+	if strings.HasPrefix(rule, "asn:") {
+		asnStr := strings.TrimPrefix(rule, "asn:")
+		if _, err := strconv.Atoi(asnStr); err != nil {
+			return fmt.Errorf("ASN 号码格式无效: %s", rule)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,89 @@
+package config
+
+import "testing"
+
+// socks5Outbounds 测试用的 SOCKS5 出站
+var socks5Outbounds = []OutboundConfig{
+	{Tag: "hk", Type: "socks5", Enable: true, Server: "127.0.0.1", Port: 1080},
+}
+
+func TestValidateOutboundProxyNameservers(t *testing.T) {
+	tests := []struct {
+		nameserver string
+		wantErr    bool
+	}{
+		{"https://dns.google/dns-query", false},
+		{"tls://dns.google", false},
+		{"quic://dns.adguard-dns.com", false},
+		{"tcp://8.8.8.8:53", false},
+		{"udp://8.8.8.8:53", false},
+		{"8.8.8.8", false},
+		{"8.8.8.8:53", false},
+		{"[2001:4860:4860::8888]:53", false},
+		{"udp://", true},
+		{"sdns://AQcAAAAAAAAA", true},
+		{"h3://dns.google/dns-query", true},
+	}
+
+	for _, tt := range tests {
+		groups := map[string]*UpstreamGroupConfig{
+			"proxy": {Nameservers: []string{tt.nameserver}, Outbound: "hk"},
+		}
+		err := validateOutbound(socks5Outbounds, groups)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("nameserver %s: err = %v, wantErr %v", tt.nameserver, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateOutboundDirectAllowsAnyNameserver(t *testing.T) {
+	groups := map[string]*UpstreamGroupConfig{
+		"direct": {Nameservers: []string{"sdns://AQcAAAAAAAAA", "h3://dns.google/dns-query"}},
+	}
+	if err := validateOutbound(socks5Outbounds, groups); err != nil {
+		t.Fatalf("direct 组不应限制 nameserver 协议: %v", err)
+	}
+}
+
+func TestValidateUpstreamGroupBuiltinRaceGroup(t *testing.T) {
+	cfg := &Config{UpstreamGroup: map[string]*UpstreamGroupConfig{
+		"proxy":  {Nameservers: []string{"8.8.8.8"}},
+		"direct": {Nameservers: []string{"223.5.5.5"}},
+	}}
+	applyDefaults(cfg)
+
+	// 使用内置 race 组时，其引用的 proxy_ecs 必须存在
+	if err := validateUpstreamGroup(cfg.UpstreamGroup, requiredUpstreamGroups(cfg)); err == nil {
+		t.Fatal("使用内置 race 组且缺少 proxy_ecs 时应报错")
+	}
+
+	cfg.UpstreamGroup["proxy_ecs"] = &UpstreamGroupConfig{Nameservers: []string{"8.8.8.8"}}
+	if err := validateUpstreamGroup(cfg.UpstreamGroup, requiredUpstreamGroups(cfg)); err != nil {
+		t.Fatalf("内置 race 组引用的组齐全时不应报错: %v", err)
+	}
+}
+
+func TestValidateUpstreamGroupCustomRaceGroup(t *testing.T) {
+	cfg := &Config{
+		UpstreamGroup: map[string]*UpstreamGroupConfig{
+			"cn":   {Nameservers: []string{"223.5.5.5"}},
+			"intl": {Nameservers: []string{"8.8.8.8"}},
+		},
+		RaceGroup: map[string]*RaceGroupConfig{
+			DefaultRaceGroup: {Primary: "intl", Fallback: "cn"},
+		},
+		Fallback: FallbackConfig{Rule: []string{"geoip:cn"}},
+	}
+	applyDefaults(cfg)
+
+	// 自定义了 proxy_ecs_fallback 时不再要求 proxy、proxy_ecs 和 direct
+	if got := requiredUpstreamGroups(cfg); len(got) != 0 {
+		t.Fatalf("requiredUpstreamGroups = %v, 期望为空", got)
+	}
+	if err := validateUpstreamGroup(cfg.UpstreamGroup, requiredUpstreamGroups(cfg)); err != nil {
+		t.Fatalf("自定义 race 组时不应要求内置组: %v", err)
+	}
+	if err := validateRaceGroup(cfg.RaceGroup, cfg.UpstreamGroup); err != nil {
+		t.Fatalf("自定义 race 组验证失败: %v", err)
+	}
+}
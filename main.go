@@ -28,7 +28,9 @@ func main() {
 	// 解析命令行参数
 	configFile := flag.String("c", "config.yaml", "配置文件路径")
 	runtimeDir := flag.String("d", "", "运行目录（配置文件和数据文件的目录）")
-	loadMode := flag.Bool("load", false, "预加载模式：加载域名分类到 Redis 后退出")
+	loadMode := flag.Bool("load", false, "校验模式：编译域名分类规则后退出")
+	auditFilter := flag.String("audit", "", "列出符合条件的学习分类及依据后退出（如 rule=geoip:cn,since=24h，all 表示全部）")
+	revertFilter := flag.String("revert", "", "删除符合条件的学习分类后退出（条件格式同 -audit）")
 	flag.Parse()
 
 	// 如果指定了运行目录，切换到该目录并查找配置文件
@@ -166,41 +168,67 @@ func main() {
 	}
 
 	// 创建分类缓存
+	// 学习到的分类按 category_cache.ttl 过期，站点更换 CDN 后会重新判断
 	var categoryCache cache.CategoryCache
+	categoryTTL := time.Duration(cfg.Cache.CategoryCache.TTL) * time.Second
 	if cfg.Cache.CategoryCache.Type == "redis" && redisClient != nil {
-		categoryCache = cache.NewRedisCategoryCache(redisClient)
+		categoryCache = cache.NewRedisCategoryCache(redisClient, categoryTTL)
 	} else {
-		categoryCache = cache.NewMemoryCategoryCache()
+		categoryCache = cache.NewMemoryCategoryCache(categoryTTL)
 	}
 
-	// Load 模式：倒序加载域名分类到 Redis 后退出
-	if *loadMode {
-		tmpLogger.Info("=== Load 模式：倒序加载域名分类 ===")
-
-		if categoryCache == nil {
-			tmpLogger.Error("Load 模式需要 Redis 缓存，但 Redis 连接失败")
+	// 审计/撤销模式：处理学习到的分类后退出
+	if *auditFilter != "" || *revertFilter != "" {
+		filterExpr, revert := *auditFilter, false
+		if *revertFilter != "" {
+			filterExpr, revert = *revertFilter, true
+		}
+		if err := auditLearned(categoryCache, filterExpr, revert, tmpLogger); err != nil {
+			tmpLogger.Error("处理学习分类失败: %v", err)
 			os.Exit(1)
 		}
+		os.Exit(0)
+	}
 
-		// 根据配置决定是否清空缓存
-		if cfg.Cache.CategoryCache.Clear {
-			categoryCache.Clear()
-			tmpLogger.Info("已清空分类缓存")
-		}
+	// 一次性清理旧版本写入 Redis 的预加载分类（后台执行，不阻塞启动）
+	if redisCategoryCache, ok := categoryCache.(*cache.RedisCategoryCache); ok {
+		go func() {
+			deleted, err := redisCategoryCache.CleanupLegacy()
+			if err != nil {
+				tmpLogger.Warn("清理旧版本预加载分类失败（下次启动重试）: %v", err)
+			} else if deleted > 0 {
+				tmpLogger.Info("已清理旧版本预加载分类: %d 个键", deleted)
+			}
+		}()
+	}
 
-		loader := category.NewLoader(categoryCache)
-		if err := loader.LoadReverse("dlc.dat", cfg.CategoryPolicy.Preload.DomainGroup); err != nil {
-			tmpLogger.Error("倒序加载域名分类失败: %v", err)
-			os.Exit(1)
+	// 编译域名分类规则（进程内匹配，分类缓存仅保存学习到的分类）
+	domainSet := category.NewDomainSet()
+	ruleSources := category.NewSources("ruleset", fileDownloadOutbound)
+	if err := category.NewLoader(domainSet, ruleSources).Load("dlc.dat", cfg.CategoryPolicy.Preload.DomainGroup); err != nil {
+		tmpLogger.Error("加载域名分类失败: %v", err)
+		os.Exit(1)
+	}
+	full, suffix, keywords, regexes := domainSet.Stats()
+	tmpLogger.Info("域名分类加载成功 (第 %d 代): full=%d domain=%d keyword=%d regexp=%d",
+		domainSet.Generation(), full, suffix, keywords, regexes)
+
+	// Load 模式：校验域名分类规则后退出
+	if *loadMode {
+		// 根据配置清除旧版本写入 Redis 的预加载分类（学习到的分类和学习依据保留）
+		if redisCategoryCache, ok := categoryCache.(*cache.RedisCategoryCache); ok && cfg.Cache.CategoryCache.Clear {
+			deleted, err := redisCategoryCache.CleanupLegacy()
+			if err != nil {
+				tmpLogger.Error("清理旧版本预加载分类失败: %v", err)
+				os.Exit(1)
+			}
+			tmpLogger.Info("已清理旧版本预加载分类: %d 个键", deleted)
 		}
 
-		tmpLogger.Info("域名分类倒序加载成功，程序退出")
+		tmpLogger.Info("域名分类校验成功，程序退出")
 		os.Exit(0)
 	}
 
-	// 正常模式：不执行预加载（预加载通过 -load 模式单独执行）
-	tmpLogger.Info("跳过域名分类预加载（使用 -load 参数单独执行）")
-
 	// 阶段 5: 组件初始化
 	tmpLogger.Info("=== 阶段 5: 组件初始化 ===")
 
@@ -291,9 +319,15 @@ func main() {
 		geoipMatcher,
 		dnsCache,
 		categoryCache,
+		domainSet,
 		logger,
-		cfg.Fallback.Rule, // fallback 规则
 	)
+	queryRouter.SetDefaultAutoCategorize(cfg.Fallback.AutoCategorize)
+
+	// 加载 race 组
+	for name, groupCfg := range cfg.RaceGroup {
+		queryRouter.AddRaceGroup(router.NewRaceGroup(name, groupCfg))
+	}
 
 	// 加载策略
 	for _, policyCfg := range cfg.QueryPolicy {
@@ -309,12 +343,14 @@ func main() {
 	defer cancel()
 
 	// 启动定时更新
-	if cfg.CategoryPolicy.Preload.Update != "" {
+	if cfg.CategoryPolicy.Preload.Update != "" || cfg.CategoryPolicy.Preload.DomainGroup.HasUpdates() {
 		updater := category.NewUpdater(
-			category.NewLoader(categoryCache),
+			category.NewLoader(domainSet, ruleSources),
 			cfg.CategoryPolicy.Preload.Update,
+			cfg.CategoryPolicy.Preload.File,
 			"dlc.dat",
 			cfg.CategoryPolicy.Preload.DomainGroup,
+			fileDownloadOutbound,
 		)
 		if err := updater.Start(ctx); err != nil {
 			logger.Warn("启动定时更新失败: %v", err)
@@ -324,13 +360,11 @@ func main() {
 	}
 
 	// 启动 DNS Server
-	dnsServer := server.NewServer(cfg.Server.Port, cfg.Server.Bind, queryRouter, logger)
+	dnsServer := server.NewServer(cfg.Server, queryRouter, logger)
 
+	serverDone := make(chan error, 1)
 	go func() {
-		if err := dnsServer.Start(ctx); err != nil {
-			logger.Error("DNS 服务器错误: %v", err)
-			os.Exit(1)
-		}
+		serverDone <- dnsServer.Start(ctx)
 	}()
 
 	// 等待信号
@@ -339,13 +373,70 @@ func main() {
 
 	logger.Info("监听地址: %s:%d", cfg.Server.Bind, cfg.Server.Port)
 
-	<-sigChan
+	select {
+	case <-sigChan:
+	case err := <-serverDone:
+		logger.Error("DNS 服务器错误: %v", err)
+		os.Exit(1)
+	}
 
 	logger.Info("正在优雅关闭...")
 	cancel()
 
-	// 给一些时间让正在处理的查询完成
-	time.Sleep(2 * time.Second)
+	// 等待所有监听器关闭、正在处理的查询完成
+	select {
+	case err := <-serverDone:
+		if err != nil {
+			logger.Warn("DNS 服务器关闭时出错: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		logger.Warn("等待 DNS 服务器关闭超时")
+	}
 
 	logger.Info("服务器已停止")
 }
+
+// auditLearned 列出（revert 为 true 时删除）符合条件的学习分类
+func auditLearned(categoryCache cache.CategoryCache, filterExpr string, revert bool, logger *middleware.Logger) error {
+	filter, err := cache.ParseEvidenceFilter(filterExpr)
+	if err != nil {
+		return err
+	}
+
+	var matched []string
+	err = categoryCache.Range(func(domain, category string, evidence *cache.Evidence) bool {
+		if !filter.Match(category, evidence) {
+			return true
+		}
+		matched = append(matched, domain)
+
+		if evidence == nil {
+			logger.Info("%s -> %s (无学习依据)", domain, category)
+		} else {
+			rule := evidence.Rule
+			if rule == "" {
+				rule = "none"
+			}
+			logger.Info("%s -> %s race_group=%s group=%s rule=%s ips=%v time=%s",
+				domain, category, evidence.RaceGroup, evidence.Group, rule, evidence.IPs,
+				evidence.Time.Format(time.RFC3339))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if !revert {
+		logger.Info("共 %d 条学习分类符合条件", len(matched))
+		return nil
+	}
+
+	for _, domain := range matched {
+		if err := categoryCache.Delete(domain); err != nil {
+			return fmt.Errorf("删除 %s 失败: %w", domain, err)
+		}
+	}
+	logger.Info("已删除 %d 条学习分类", len(matched))
+	return nil
+}
@@ -476,18 +476,22 @@ func (l *Logger) LogCacheSet(ctx context.Context, domain string, qtype uint16, t
 // 域名分类和策略匹配
 // =============================================================================
 
-// LogCategoryMatch 记录域名分类匹配
-func (l *Logger) LogCategoryMatch(ctx context.Context, domain, category string, matched bool) {
+// LogCategoryMatch 记录域名分类匹配（origin 为分类来源: inline / preload / learned）
+func (l *Logger) LogCategoryMatch(ctx context.Context, domain, category, origin string, matched bool) {
 	event := "category_matched"
 	if !matched {
 		event = "category_not_matched"
 		category = "unknown"
 	}
-	l.withTraceID(ctx).WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"event":    event,
 		"domain":   domain,
 		"category": category,
-	}).Debug("分类匹配")
+	}
+	if origin != "" {
+		fields["origin"] = origin
+	}
+	l.withTraceID(ctx).WithFields(fields).Debug("分类匹配")
 }
 
 // LogPolicyMatch 记录策略匹配
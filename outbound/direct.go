@@ -18,3 +18,9 @@ func (o *DirectOutbound) Dial(ctx context.Context, network, address string) (net
 	var d net.Dialer
 	return d.DialContext(ctx, network, address)
 }
+
+// DialUDP 建立 UDP 连接
+func (o *DirectOutbound) DialUDP(ctx context.Context, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "udp", address)
+}
@@ -8,4 +8,6 @@ import (
 // Outbound 出站接口
 type Outbound interface {
 	Dial(ctx context.Context, network, address string) (net.Conn, error)
+	// DialUDP 建立到 address 的 UDP 连接，返回的连接同时实现 net.PacketConn
+	DialUDP(ctx context.Context, address string) (net.Conn, error)
 }
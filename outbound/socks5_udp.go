@@ -0,0 +1,335 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5 协议常量（RFC 1928 / RFC 1929）
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xFF
+	socks5CmdUDP       = 0x03
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+)
+
+// socks5Replies SOCKS5 应答码说明
+var socks5Replies = map[byte]string{
+	0x01: "服务器内部错误",
+	0x02: "规则不允许",
+	0x03: "网络不可达",
+	0x04: "主机不可达",
+	0x05: "连接被拒绝",
+	0x06: "TTL 过期",
+	0x07: "不支持的命令",
+	0x08: "不支持的地址类型",
+}
+
+// ErrUDPRefused SOCKS5 服务器拒绝 UDP ASSOCIATE（代理不支持或不允许 UDP）
+var ErrUDPRefused = errors.New("SOCKS5 服务器拒绝 UDP ASSOCIATE")
+
+// socks5UDPHeaderMax UDP 中继头最大长度: RSV(2) FRAG(1) ATYP(1) 域名(1+255) PORT(2)
+const socks5UDPHeaderMax = 262
+
+// socks5HandshakeTimeout UDP ASSOCIATE 握手超时（ctx 没有截止时间时使用）
+const socks5HandshakeTimeout = 10 * time.Second
+
+// DialUDP 通过 SOCKS5 UDP ASSOCIATE 建立到 address 的 UDP 中继
+// 控制连接在中继关闭前一直保持，代理关闭控制连接时中继随之失效
+func (o *SOCKS5Outbound) DialUDP(ctx context.Context, address string) (net.Conn, error) {
+	target, err := socks5EncodeAddr(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	ctrl, err := d.DialContext(ctx, "tcp", net.JoinHostPort(o.server, strconv.Itoa(o.port)))
+	if err != nil {
+		return nil, fmt.Errorf("连接 SOCKS5 服务器失败: %w", err)
+	}
+
+	relay, err := o.associate(ctx, ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	udp, err := d.DialContext(ctx, "udp", relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("连接 SOCKS5 UDP 中继失败: %w", err)
+	}
+
+	c := &socks5UDPConn{
+		Conn:   udp,
+		ctrl:   ctrl,
+		target: target,
+		remote: &udpAddr{address: address},
+	}
+
+	// 控制连接断开后关闭中继
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		c.Close()
+	}()
+
+	return c, nil
+}
+
+// associate 完成认证并发送 UDP ASSOCIATE 请求，返回中继地址
+func (o *SOCKS5Outbound) associate(ctx context.Context, conn net.Conn) (string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(socks5HandshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	if err := o.authenticate(conn); err != nil {
+		return "", err
+	}
+
+	// 请求: VER CMD RSV ATYP DST.ADDR DST.PORT（客户端地址未知，填全零）
+	req := []byte{socks5Version, socks5CmdUDP, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := conn.Write(req); err != nil {
+		return "", fmt.Errorf("发送 UDP ASSOCIATE 请求失败: %w", err)
+	}
+
+	// 应答: VER REP RSV ATYP BND.ADDR BND.PORT
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("读取 UDP ASSOCIATE 应答失败: %w", err)
+	}
+	if header[1] != 0x00 {
+		reason, ok := socks5Replies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("未知错误 0x%02x", header[1])
+		}
+		return "", fmt.Errorf("%w: %s", ErrUDPRefused, reason)
+	}
+
+	host, port, err := socks5ReadAddr(conn)
+	if err != nil {
+		return "", fmt.Errorf("读取 UDP 中继地址失败: %w", err)
+	}
+
+	// 中继地址为全零时使用代理服务器地址
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = o.server
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// authenticate 协商认证方式（无认证或用户名密码）
+func (o *SOCKS5Outbound) authenticate(conn net.Conn) error {
+	methods := []byte{socks5Version, 1, socks5AuthNone}
+	if o.username != "" {
+		methods = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return fmt.Errorf("发送 SOCKS5 握手失败: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取 SOCKS5 握手应答失败: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("SOCKS5 服务器版本错误: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if o.username == "" {
+			return errors.New("SOCKS5 服务器要求用户名密码认证")
+		}
+		req := []byte{0x01, byte(len(o.username))}
+		req = append(req, o.username...)
+		req = append(req, byte(len(o.password)))
+		req = append(req, o.password...)
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("发送 SOCKS5 认证失败: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("读取 SOCKS5 认证应答失败: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 用户名或密码错误")
+		}
+		return nil
+	case socks5AuthNoAccept:
+		return errors.New("SOCKS5 服务器不接受任何认证方式")
+	default:
+		return fmt.Errorf("SOCKS5 服务器选择了不支持的认证方式: %d", reply[1])
+	}
+}
+
+// socks5EncodeAddr 编码目标地址: ATYP DST.ADDR DST.PORT
+func socks5EncodeAddr(address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("无效的地址 %s: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("无效的端口: %s", address)
+	}
+
+	var buf []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append([]byte{socks5AtypIPv4}, ip4...)
+		} else {
+			buf = append([]byte{socks5AtypIPv6}, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("域名过长: %s", host)
+		}
+		buf = append([]byte{socks5AtypDomain, byte(len(host))}, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// socks5ReadAddr 读取 ATYP ADDR PORT
+func socks5ReadAddr(r io.Reader) (string, int, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if atyp[0] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(r, atyp); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, atyp[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", 0, fmt.Errorf("不支持的地址类型: %d", atyp[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// udpAddr 中继目标地址（可能是域名，由代理服务器解析）
+type udpAddr struct {
+	address string
+}
+
+func (a *udpAddr) Network() string { return "udp" }
+func (a *udpAddr) String() string  { return a.address }
+
+// socks5UDPConn 经 SOCKS5 中继的 UDP 连接
+// 同时实现 net.Conn 和 net.PacketConn：所有数据包都发往同一目标，收到的数据包都视为来自该目标
+type socks5UDPConn struct {
+	net.Conn          // 到中继的 UDP 连接
+	ctrl     net.Conn // 控制连接
+	target   []byte   // 编码后的目标地址
+	remote   net.Addr
+
+	closeOnce sync.Once
+}
+
+// Read 读取一个数据包（去掉中继头，丢弃分片包）
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	buf := make([]byte, socks5UDPHeaderMax+len(b))
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		// RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT DATA
+		if n < 4 || buf[2] != 0x00 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		if _, _, err := socks5ReadAddr(r); err != nil {
+			continue
+		}
+		return copy(b, buf[n-r.Len():n]), nil
+	}
+}
+
+// Write 发送一个数据包（加上中继头）
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	packet := make([]byte, 0, 3+len(c.target)+len(b))
+	packet = append(packet, 0x00, 0x00, 0x00)
+	packet = append(packet, c.target...)
+	packet = append(packet, b...)
+
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom 实现 net.PacketConn
+func (c *socks5UDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.remote, err
+}
+
+// WriteTo 实现 net.PacketConn（忽略 addr，总是发往连接目标）
+func (c *socks5UDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+// RemoteAddr 返回中继目标地址
+func (c *socks5UDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetReadBuffer 设置接收缓冲区大小（QUIC 需要较大的缓冲区）
+func (c *socks5UDPConn) SetReadBuffer(size int) error {
+	return c.Conn.(*net.UDPConn).SetReadBuffer(size)
+}
+
+// SetWriteBuffer 设置发送缓冲区大小
+func (c *socks5UDPConn) SetWriteBuffer(size int) error {
+	return c.Conn.(*net.UDPConn).SetWriteBuffer(size)
+}
+
+// Close 关闭中继和控制连接
+func (c *socks5UDPConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		c.ctrl.Close()
+	})
+	return err
+}
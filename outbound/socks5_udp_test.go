@@ -0,0 +1,169 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeSOCKS5 回环地址上的 SOCKS5 服务器，只处理 UDP ASSOCIATE
+// rep 为 0 时返回 relay 的地址作为中继地址，否则以 rep 拒绝请求
+type fakeSOCKS5 struct {
+	ln    net.Listener
+	relay net.PacketConn
+	rep   byte
+}
+
+func newFakeSOCKS5(t *testing.T, rep byte) *fakeSOCKS5 {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSOCKS5{ln: ln, relay: relay, rep: rep}
+	t.Cleanup(func() {
+		ln.Close()
+		relay.Close()
+	})
+
+	go s.serve()
+	return s
+}
+
+func (s *fakeSOCKS5) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSOCKS5) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// 认证协商: VER NMETHODS METHODS
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, socks5AuthNone})
+
+	// 请求: VER CMD RSV ATYP(IPv4) ADDR(4) PORT(2)
+	if _, err := io.ReadFull(conn, buf[:10]); err != nil || buf[1] != socks5CmdUDP {
+		return
+	}
+
+	if s.rep != 0x00 {
+		conn.Write([]byte{socks5Version, s.rep, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	addr := s.relay.LocalAddr().(*net.UDPAddr)
+	reply := []byte{socks5Version, 0x00, 0x00, socks5AtypIPv4}
+	reply = append(reply, addr.IP.To4()...)
+	reply = append(reply, byte(addr.Port>>8), byte(addr.Port))
+	conn.Write(reply)
+
+	// 保持控制连接直到客户端关闭
+	io.Copy(io.Discard, conn)
+}
+
+func (s *fakeSOCKS5) outbound(t *testing.T) *SOCKS5Outbound {
+	t.Helper()
+
+	addr := s.ln.Addr().(*net.TCPAddr)
+	ob, err := NewSOCKS5Outbound("127.0.0.1", addr.Port, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ob
+}
+
+func TestSOCKS5UDPConnFraming(t *testing.T) {
+	server := newFakeSOCKS5(t, 0x00)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := server.outbound(t).DialUDP(ctx, "dns.google:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Write: RSV(2) FRAG(1) ATYP(域名) LEN "dns.google" PORT(53) DATA
+	if _, err := conn.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	server.relay.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, client, err := server.relay.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{0x00, 0x00, 0x00, socks5AtypDomain, 10}, "dns.google"...)
+	want = append(want, 0x00, 53)
+	want = append(want, "query"...)
+	if !bytes.Equal(buf[:n], want) {
+		t.Fatalf("发送的数据包 = %v, 期望 %v", buf[:n], want)
+	}
+
+	// 分片包应被丢弃，随后的完整包去掉中继头后返回
+	fragment := []byte{0x00, 0x00, 0x01, socks5AtypIPv4, 8, 8, 8, 8, 0x00, 53}
+	fragment = append(fragment, "fragment"...)
+	server.relay.WriteTo(fragment, client)
+
+	reply := []byte{0x00, 0x00, 0x00, socks5AtypIPv6}
+	reply = append(reply, net.ParseIP("2001:4860:4860::8888").To16()...)
+	reply = append(reply, 0x00, 53)
+	reply = append(reply, "answer"...)
+	server.relay.WriteTo(reply, client)
+
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "answer" {
+		t.Fatalf("Read = %q, 期望 %q", got, "answer")
+	}
+
+	// ReadFrom 返回的地址总是中继目标
+	server.relay.WriteTo(reply, client)
+	_, from, err := conn.(net.PacketConn).ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "dns.google:53" {
+		t.Fatalf("ReadFrom 地址 = %s, 期望 dns.google:53", from)
+	}
+}
+
+func TestSOCKS5DialUDPRefused(t *testing.T) {
+	for _, rep := range []byte{0x02, 0x07, 0x42} {
+		server := newFakeSOCKS5(t, rep)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := server.outbound(t).DialUDP(ctx, "8.8.8.8:53")
+		cancel()
+
+		if !errors.Is(err, ErrUDPRefused) {
+			t.Errorf("REP=0x%02x: err = %v, 期望 ErrUDPRefused", rep, err)
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 // QueryRouter DNS 查询路由器接口
 type QueryRouter interface {
 	// Route 路由查询
-	Route(ctx context.Context, domain string, qtype uint16) (*dns.Msg, error)
+	Route(ctx context.Context, req *Request) (*dns.Msg, error)
 
 	// AddPolicy 添加策略
 	AddPolicy(policy *Policy)
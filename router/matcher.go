@@ -4,51 +4,68 @@ import (
 	"strings"
 
 	"violet-dns/cache"
+	"violet-dns/category"
 )
 
-// Matcher 域名匹配器（从 CategoryCache 查询）
+// Matcher 域名匹配器
+// 预加载分类从进程内规则集匹配，学习到的分类从 CategoryCache 查询（每次最多一次 Redis 往返）
 type Matcher struct {
 	categoryCache cache.CategoryCache
+	domains       *category.DomainSet
 }
 
-// NewMatcher 创建新的匹配器
-func NewMatcher(categoryCache cache.CategoryCache) *Matcher {
+// NewMatcher 创建新的匹配器，domains 可为 nil
+func NewMatcher(categoryCache cache.CategoryCache, domains *category.DomainSet) *Matcher {
 	return &Matcher{
 		categoryCache: categoryCache,
+		domains:       domains,
 	}
 }
 
 // Match 匹配域名
 // 返回匹配的分组和是否匹配成功
-// 支持逐级向上查找: www.google.com -> google.com -> com
 func (m *Matcher) Match(domain string) (string, bool) {
-	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
-	if domain == "" {
-		return "", false
-	}
+	group, _, ok := m.MatchOrigin(domain)
+	return group, ok
+}
 
-	// 1. 先查询完整域名 (如 www.google.com)
-	if group, err := m.categoryCache.Get(domain); err == nil && group != "" {
-		return group, true
+// MatchOrigin 匹配域名并返回分类来源（category.OriginInline / OriginPreload / OriginLearned）
+// 优先级: 内联规则 > 预加载规则（full > domain 最长后缀 > keyword > regexp）> 学习到的分类
+func (m *Matcher) MatchOrigin(domain string) (string, string, bool) {
+	if group, origin, ok := m.MatchLocal(domain); ok {
+		return group, origin, true
+	}
+	if group, ok := m.MatchLearned(domain); ok {
+		return group, category.OriginLearned, true
 	}
+	return "", "", false
+}
 
-	// 2. 逐级向上查找父域名
-	parts := strings.Split(domain, ".")
-	for i := 1; i < len(parts); i++ {
-		parentDomain := strings.Join(parts[i:], ".")
-		if group, err := m.categoryCache.Get(parentDomain); err == nil && group != "" {
-			return group, true
-		}
+// MatchLocal 只匹配进程内的内联规则和预加载规则（不访问分类缓存）
+func (m *Matcher) MatchLocal(domain string) (string, string, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" || m.domains == nil {
+		return "", "", false
 	}
+	return m.domains.Match(domain)
+}
 
-	// 3. 未匹配
+// MatchLearned 只匹配学习到的分类（一次查询域名自身及所有父域名，取最具体的匹配）
+func (m *Matcher) MatchLearned(domain string) (string, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" || m.categoryCache == nil {
+		return "", false
+	}
+	if _, group, err := m.categoryCache.GetLongestSuffix(domain); err == nil && group != "" {
+		return group, true
+	}
 	return "", false
 }
 
-// MatchExact 精确匹配域名（不支持父域名查找）
+// MatchExact 精确匹配学习到的分类（不支持父域名查找）
 func (m *Matcher) MatchExact(domain string) (string, bool) {
 	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
-	if domain == "" {
+	if domain == "" || m.categoryCache == nil {
 		return "", false
 	}
 
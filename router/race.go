@@ -0,0 +1,348 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"violet-dns/cache"
+	"violet-dns/config"
+	"violet-dns/utils"
+
+	"github.com/miekg/dns"
+)
+
+// race 组查询策略
+const (
+	raceStrategyRace        = "race"         // 并发查询 primary 和 secondary
+	raceStrategySequential  = "sequential"   // 先查询 primary，仅在失败时查询 secondary
+	raceStrategyDirectFirst = "direct_first" // 先查询 fallback 组，结果不匹配规则时才经过代理查询
+)
+
+// RaceGroup 按结果 IP 分类的组
+// Primary 结果 IP 匹配 Rules 时改用 Fallback 组，否则使用 Secondary（失败时使用 Primary）的结果，
+// 并将判断结果写入分类缓存；Strategy 决定各组的查询顺序
+type RaceGroup struct {
+	Name          string
+	Primary       string
+	Secondary     string
+	Fallback      string
+	Strategy      string
+	Rules         []string
+	Timeout       time.Duration
+	MatchCategory string
+	MissCategory  string
+}
+
+// NewRaceGroup 从配置创建 race 组
+func NewRaceGroup(name string, cfg *config.RaceGroupConfig) *RaceGroup {
+	return &RaceGroup{
+		Name:          name,
+		Primary:       cfg.Primary,
+		Secondary:     cfg.Secondary,
+		Fallback:      cfg.Fallback,
+		Strategy:      cfg.Strategy,
+		Rules:         cfg.Rule,
+		Timeout:       time.Duration(cfg.Timeout) * time.Second,
+		MatchCategory: cfg.MatchCategory,
+		MissCategory:  cfg.MissCategory,
+	}
+}
+
+// handleRaceGroup 处理 race 组策略
+func (r *Router) handleRaceGroup(ctx context.Context, domain string, qtype uint16,
+	group *RaceGroup, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	switch group.Strategy {
+	case raceStrategySequential:
+		return r.raceSequential(ctx, domain, qtype, group, policy, startTime)
+	case raceStrategyDirectFirst:
+		return r.raceDirectFirst(ctx, domain, qtype, group, policy, startTime)
+	default:
+		return r.raceConcurrent(ctx, domain, qtype, group, policy, startTime)
+	}
+}
+
+// raceConcurrent 并发查询 primary 和 secondary（race 策略）
+func (r *Router) raceConcurrent(ctx context.Context, domain string, qtype uint16,
+	group *RaceGroup, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	groups := []string{group.Primary}
+	if group.Secondary != "" {
+		groups = append(groups, group.Secondary)
+	}
+
+	r.logger.LogProxyECSFallback(ctx, domain, "开始并发查询", map[string]interface{}{
+		"race_group": group.Name,
+		"groups":     groups,
+	})
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+		from string
+	}
+
+	resChan := make(chan result, len(groups))
+
+	// 并发查询 primary 和 secondary
+	for _, name := range groups {
+		go func(name string) {
+			resp, err := r.upstreamMgr.Query(ctx, name, domain, qtype)
+			resChan <- result{resp: resp, err: err, from: name}
+		}(name)
+	}
+
+	// 等待结果
+	var primaryResp *dns.Msg
+	var secondaryResp *dns.Msg
+
+	timeout := time.After(group.Timeout)
+wait:
+	for i := 0; i < len(groups); i++ {
+		select {
+		case res := <-resChan:
+			if res.err != nil {
+				r.logger.LogProxyECSFallback(ctx, domain, res.from+"查询失败", map[string]interface{}{
+					"race_group": group.Name,
+					"error":      res.err.Error(),
+				})
+				continue
+			}
+
+			r.logger.LogProxyECSFallback(ctx, domain, res.from+"查询成功", map[string]interface{}{
+				"race_group":   group.Name,
+				"answer_count": len(res.resp.Answer),
+			})
+			// primary 与 secondary 可能是同一个组
+			if res.from == group.Primary && primaryResp == nil {
+				primaryResp = res.resp
+			} else {
+				secondaryResp = res.resp
+			}
+		case <-timeout:
+			r.logger.LogProxyECSFallback(ctx, domain, "查询超时", map[string]interface{}{
+				"race_group": group.Name,
+				"timeout":    group.Timeout.String(),
+			})
+			break wait
+		}
+	}
+
+	// 检查 primary 结果是否匹配规则
+	var verdict *raceVerdict
+	if primaryResp != nil {
+		verdict = r.matchRaceRules(ctx, domain, qtype, primaryResp, group, group.Primary)
+		if verdict.rule != "" {
+			return r.raceFallback(ctx, domain, qtype, group, verdict, policy, startTime)
+		}
+	}
+
+	// 使用 secondary 结果
+	if secondaryResp != nil {
+		return r.finishRaceGroup(ctx, domain, qtype, secondaryResp, group.Secondary, group.MissCategory, group, verdict, policy, startTime), nil
+	}
+
+	// 使用 primary 结果
+	if primaryResp != nil {
+		return r.finishRaceGroup(ctx, domain, qtype, primaryResp, group.Primary, group.MissCategory, group, verdict, policy, startTime), nil
+	}
+
+	r.logger.LogError(ctx, "RaceGroup全部失败", domain, fmt.Errorf("所有查询失败"), map[string]interface{}{
+		"race_group": group.Name,
+	})
+	return nil, fmt.Errorf("所有查询失败")
+}
+
+// raceSequential 先查询 primary，仅在 primary 失败时查询 secondary（sequential 策略，节省代理流量）
+func (r *Router) raceSequential(ctx context.Context, domain string, qtype uint16,
+	group *RaceGroup, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	r.logger.LogProxyECSFallback(ctx, domain, "开始顺序查询", map[string]interface{}{
+		"race_group": group.Name,
+		"groups":     []string{group.Primary, group.Secondary},
+	})
+
+	primaryResp, err := r.raceQuery(ctx, domain, qtype, group, group.Primary)
+	if err == nil {
+		verdict := r.matchRaceRules(ctx, domain, qtype, primaryResp, group, group.Primary)
+		if verdict.rule != "" {
+			return r.raceFallback(ctx, domain, qtype, group, verdict, policy, startTime)
+		}
+		return r.finishRaceGroup(ctx, domain, qtype, primaryResp, group.Primary, group.MissCategory, group, verdict, policy, startTime), nil
+	}
+
+	if group.Secondary == "" {
+		return nil, err
+	}
+
+	secondaryResp, err := r.raceQuery(ctx, domain, qtype, group, group.Secondary)
+	if err != nil {
+		return nil, err
+	}
+	return r.finishRaceGroup(ctx, domain, qtype, secondaryResp, group.Secondary, group.MissCategory, group, nil, policy, startTime), nil
+}
+
+// raceDirectFirst 先查询 fallback 组，结果不匹配规则时才经过 secondary / primary 查询（direct_first 策略）
+func (r *Router) raceDirectFirst(ctx context.Context, domain string, qtype uint16,
+	group *RaceGroup, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	r.logger.LogProxyECSFallback(ctx, domain, "开始直连优先查询", map[string]interface{}{
+		"race_group": group.Name,
+		"groups":     []string{group.Fallback, group.Secondary, group.Primary},
+	})
+
+	var verdict *raceVerdict
+	fallbackResp, err := r.raceQuery(ctx, domain, qtype, group, group.Fallback)
+	if err == nil {
+		verdict = r.matchRaceRules(ctx, domain, qtype, fallbackResp, group, group.Fallback)
+		if verdict.rule != "" {
+			return r.finishRaceGroup(ctx, domain, qtype, fallbackResp, group.Fallback, group.MatchCategory, group, verdict, policy, startTime), nil
+		}
+	}
+
+	// fallback 组失败或结果不匹配规则，经过代理查询
+	proxies := []string{group.Primary}
+	if group.Secondary != "" {
+		proxies = []string{group.Secondary, group.Primary}
+	}
+
+	var lastErr error
+	for _, name := range proxies {
+		resp, err := r.raceQuery(ctx, domain, qtype, group, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return r.finishRaceGroup(ctx, domain, qtype, resp, name, group.MissCategory, group, verdict, policy, startTime), nil
+	}
+
+	r.logger.LogError(ctx, "RaceGroup全部失败", domain, lastErr, map[string]interface{}{
+		"race_group": group.Name,
+	})
+	return nil, fmt.Errorf("所有查询失败: %w", lastErr)
+}
+
+// raceQuery 在 race 组超时时间内查询指定上游组
+func (r *Router) raceQuery(ctx context.Context, domain string, qtype uint16, group *RaceGroup, name string) (*dns.Msg, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, group.Timeout)
+	defer cancel()
+
+	resp, err := r.upstreamMgr.Query(queryCtx, name, domain, qtype)
+	if err != nil {
+		r.logger.LogProxyECSFallback(ctx, domain, name+"查询失败", map[string]interface{}{
+			"race_group": group.Name,
+			"error":      err.Error(),
+		})
+		return nil, err
+	}
+
+	r.logger.LogProxyECSFallback(ctx, domain, name+"查询成功", map[string]interface{}{
+		"race_group":   group.Name,
+		"answer_count": len(resp.Answer),
+	})
+	return resp, nil
+}
+
+// raceVerdict race 组对某个上游组结果的判断（写入分类缓存时作为学习依据）
+type raceVerdict struct {
+	from string   // 被判断结果来自的上游组
+	ips  []string // 结果中与查询类型对应的 IP
+	rule string   // 匹配的规则（未匹配时为空）
+}
+
+// newRaceVerdict 从响应创建未匹配规则的判断
+func newRaceVerdict(from string, qtype uint16, resp *dns.Msg) *raceVerdict {
+	addrs := raceAddrs(qtype, resp)
+	ips := make([]string, len(addrs))
+	for i, ip := range addrs {
+		ips[i] = ip.String()
+	}
+	return &raceVerdict{from: from, ips: ips}
+}
+
+// decisive 判断结果是否可以作为分类依据
+// 只有带地址的应答经过了规则判断；上游失败（verdict 为 nil）或无地址应答
+// （AAAA 查询 v4-only 站点、MX/TXT/HTTPS 等）都不能说明域名不匹配规则
+func (v *raceVerdict) decisive() bool {
+	return v != nil && len(v.ips) > 0
+}
+
+// raceAddrs 提取响应中与查询类型对应的地址记录（非 A/AAAA 查询没有可判断的地址）
+func raceAddrs(qtype uint16, resp *dns.Msg) []net.IP {
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil
+	}
+	var addrs []net.IP
+	for _, ip := range utils.ExtractIPs(resp.Answer) {
+		if (ip.To4() != nil) == (qtype == dns.TypeA) {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// matchRaceRules 检查响应中是否有 IP 匹配 race 组的规则，返回的判断中 rule 为第一个匹配的规则
+func (r *Router) matchRaceRules(ctx context.Context, domain string, qtype uint16, resp *dns.Msg, group *RaceGroup, from string) *raceVerdict {
+	verdict := newRaceVerdict(from, qtype, resp)
+
+	r.logger.LogProxyECSFallback(ctx, domain, "判断"+from+"结果是否匹配规则", map[string]interface{}{
+		"race_group": group.Name,
+		"ips":        verdict.ips,
+	})
+
+	for _, ip := range raceAddrs(qtype, resp) {
+		for _, rule := range group.Rules {
+			if r.geoipMatcher.Match(ip, rule) {
+				verdict.rule = rule
+				return verdict
+			}
+		}
+	}
+	return verdict
+}
+
+// raceFallback primary 结果匹配规则时改用 fallback 组查询
+func (r *Router) raceFallback(ctx context.Context, domain string, qtype uint16,
+	group *RaceGroup, verdict *raceVerdict, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	r.logger.LogFallback(ctx, domain, group.Primary, group.Fallback, "执行fallback到"+group.Fallback)
+
+	fallbackResp, err := r.upstreamMgr.Query(ctx, group.Fallback, domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return r.finishRaceGroup(ctx, domain, qtype, fallbackResp, group.Fallback, group.MatchCategory, group, verdict, policy, startTime), nil
+}
+
+// finishRaceGroup 处理 race 组选中的结果（过滤、缓存、写入分类）
+// verdict 为做出分类判断的依据，不能作为依据时（上游失败、无地址应答）不写入分类
+func (r *Router) finishRaceGroup(ctx context.Context, domain string, qtype uint16, resp *dns.Msg,
+	from, category string, group *RaceGroup, verdict *raceVerdict, policy *Policy, startTime time.Time) *dns.Msg {
+
+	// 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
+	if policy.Options.DisableHTTPS {
+		r.filterHTTPSRecords(ctx, resp)
+	}
+
+	// 缓存结果
+	if !policy.Options.DisableCache {
+		r.cacheResponse(ctx, domain, resp, 0)
+	}
+
+	// 异步写入域名分类缓存（仅策略开启 auto_categorize 且结果经过规则判断时）
+	if category != "" && policy.Options.AutoCategorize && verdict.decisive() {
+		go r.asyncCacheCategory(domain, category, &cache.Evidence{
+			IPs:       verdict.ips,
+			Rule:      verdict.rule,
+			Group:     verdict.from,
+			RaceGroup: group.Name,
+			Time:      time.Now(),
+		})
+	}
+
+	latency := time.Since(startTime)
+	r.logger.LogQueryComplete(ctx, domain, qtype, uint16(resp.Rcode), false, latency, from, len(resp.Answer))
+	return resp
+}
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRaceVerdictDecisive(t *testing.T) {
+	msg := func(rrs ...string) *dns.Msg {
+		resp := new(dns.Msg)
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatalf("解析记录 %q 失败: %v", s, err)
+			}
+			resp.Answer = append(resp.Answer, rr)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		resp  *dns.Msg
+		ips   int
+	}{
+		{"A 应答", dns.TypeA, msg("example.com. 60 IN A 1.2.3.4"), 1},
+		{"AAAA 应答", dns.TypeAAAA, msg("example.com. 60 IN AAAA 2001:db8::1"), 1},
+		{"AAAA 查询 v4-only 站点", dns.TypeAAAA, msg(), 0},
+		{"只有 CNAME", dns.TypeA, msg("example.com. 60 IN CNAME cdn.example.net."), 0},
+		{"MX 查询", dns.TypeMX, msg("example.com. 60 IN MX 10 mail.example.com."), 0},
+		{"HTTPS 查询附带地址", dns.TypeHTTPS, msg("example.com. 60 IN A 1.2.3.4"), 0},
+		{"AAAA 查询只统计 AAAA", dns.TypeAAAA, msg("example.com. 60 IN A 1.2.3.4"), 0},
+	}
+
+	for _, tt := range tests {
+		verdict := newRaceVerdict("direct", tt.qtype, tt.resp)
+		if len(verdict.ips) != tt.ips {
+			t.Errorf("%s: ips = %v, 期望 %d 个", tt.name, verdict.ips, tt.ips)
+		}
+		if verdict.decisive() != (tt.ips > 0) {
+			t.Errorf("%s: decisive = %v, 期望 %v", tt.name, verdict.decisive(), tt.ips > 0)
+		}
+	}
+
+	// 上游失败时没有判断，不能作为分类依据
+	var verdict *raceVerdict
+	if verdict.decisive() {
+		t.Error("nil 判断不应作为分类依据")
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Request DNS 查询请求上下文（原始请求及客户端信息）
+type Request struct {
+	Msg          *dns.Msg          // 原始请求消息
+	Domain       string            // 查询域名（小写，无尾点）
+	Qtype        uint16            // 查询类型
+	Qclass       uint16            // 查询类别
+	ClientAddr   net.Addr          // 客户端地址
+	ClientIP     net.IP            // 客户端 IP（DoH 经受信任代理时为 X-Forwarded-For 中的真实地址）
+	Transport    string            // 到达的监听器: udp, tcp, tls, https, quic
+	DNSSECOK     bool              // EDNS0 DO 位
+	UDPSize      uint16            // EDNS0 UDP 报文大小，未携带 EDNS0 时为 0
+	ClientSubnet *dns.EDNS0_SUBNET // 客户端携带的 ECS 选项
+	EDNSOptions  []dns.EDNS0       // 客户端携带的全部 EDNS0 选项
+}
+
+// NewRequest 从原始请求构建请求上下文
+func NewRequest(msg *dns.Msg, clientAddr net.Addr, transport string) *Request {
+	req := &Request{
+		Msg:        msg,
+		ClientAddr: clientAddr,
+		ClientIP:   addrIP(clientAddr),
+		Transport:  transport,
+	}
+
+	if len(msg.Question) > 0 {
+		q := msg.Question[0]
+		req.Domain = strings.ToLower(strings.TrimSuffix(q.Name, "."))
+		req.Qtype = q.Qtype
+		req.Qclass = q.Qclass
+	}
+
+	if opt := msg.IsEdns0(); opt != nil {
+		req.DNSSECOK = opt.Do()
+		req.UDPSize = opt.UDPSize()
+		req.EDNSOptions = opt.Option
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				req.ClientSubnet = subnet
+				break
+			}
+		}
+	}
+
+	return req
+}
+
+// addrIP 从 net.Addr 中提取 IP
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		return net.ParseIP(host)
+	}
+}
+
+// ClientIPString 返回客户端 IP 字符串（用于日志）
+func (r *Request) ClientIPString() string {
+	if r.ClientIP == nil {
+		return ""
+	}
+	return r.ClientIP.String()
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"violet-dns/cache"
+	"violet-dns/category"
+	"violet-dns/config"
 	"violet-dns/geoip"
 	"violet-dns/middleware"
 	"violet-dns/upstream"
@@ -23,7 +25,8 @@ type Router struct {
 	dnsCache      cache.DNSCache // 使用新的 RR 级别缓存
 	categoryCache cache.CategoryCache
 	logger        *middleware.Logger
-	fallbackRules []string // Fallback 规则
+	raceGroups    map[string]*RaceGroup // 并发查询并按 IP 分类的组
+	defaultPolicy *Policy               // 未配置 unknown 策略和 IP 验证失败回退时使用的默认策略
 }
 
 // NewRouter 创建新的路由器
@@ -32,71 +35,71 @@ func NewRouter(
 	geoipMatcher *geoip.Matcher,
 	dnsCache cache.DNSCache,
 	categoryCache cache.CategoryCache,
+	domains *category.DomainSet,
 	logger *middleware.Logger,
-	fallbackRules []string,
 ) *Router {
 	return &Router{
-		matcher:       NewMatcher(categoryCache), // 传入 categoryCache
+		matcher:       NewMatcher(categoryCache, domains), // 预加载规则 + 学习到的分类
 		policies:      make([]*Policy, 0),
 		upstreamMgr:   upstreamMgr,
 		geoipMatcher:  geoipMatcher,
 		dnsCache:      dnsCache,
 		categoryCache: categoryCache,
 		logger:        logger,
-		fallbackRules: fallbackRules,
+		raceGroups:    make(map[string]*RaceGroup),
+		defaultPolicy: NewPolicy("unknown", config.DefaultRaceGroup, config.QueryPolicyOptions{}),
 	}
 }
 
+// SetDefaultAutoCategorize 设置默认策略是否学习域名分类（fallback.auto_categorize）
+func (r *Router) SetDefaultAutoCategorize(enable bool) {
+	r.defaultPolicy.Options.AutoCategorize = enable
+}
+
 // AddPolicy 添加策略
 func (r *Router) AddPolicy(policy *Policy) {
 	r.policies = append(r.policies, policy)
 }
 
+// AddRaceGroup 添加 race 组
+func (r *Router) AddRaceGroup(group *RaceGroup) {
+	r.raceGroups[group.Name] = group
+}
+
 // Route 路由查询（支持 CNAME 链部分缓存）
-func (r *Router) Route(ctx context.Context, domain string, qtype uint16) (*dns.Msg, error) {
+func (r *Router) Route(ctx context.Context, req *Request) (*dns.Msg, error) {
 	startTime := time.Now()
+	domain, qtype := req.Domain, req.Qtype
 
 	// DEBUG: 记录查询开始
-	r.logger.LogQueryStart(ctx, "", domain, qtype)
+	r.logger.LogQueryStart(ctx, req.ClientIPString(), domain, qtype)
 
-	// 1. 尝试从缓存解析 CNAME 链
-	cachedAnswers, needUpstream, targetName := cache.ResolveCNAMEChain(r.dnsCache, domain, qtype, 10)
+	// 1. 匹配进程内规则（在缓存之前确定策略，block 和协议族策略对缓存命中同样生效）
+	groupName, origin, matched := r.matcher.MatchLocal(domain)
 
-	if !needUpstream {
-		// 完全命中缓存
-		msg := cache.BuildResponseFromCache(domain, qtype, nil)
-		msg.Answer = cachedAnswers
-		latency := time.Since(startTime)
-
-		r.logger.LogCacheHit(ctx, domain, qtype, time.Duration(cachedAnswers[0].Header().Ttl)*time.Second)
-		r.logger.LogQueryComplete(ctx, domain, qtype, uint16(msg.Rcode), true, latency, "cache", len(msg.Answer))
-		return msg, nil
-	}
-
-	// 2. 部分缓存命中或完全未命中
-	if len(cachedAnswers) > 0 {
-		r.logger.Debug("CNAME链部分缓存命中: domain=%s cached_depth=%d target=%s",
-			domain, len(cachedAnswers), targetName)
-	} else {
-		r.logger.LogCacheMiss(ctx, domain, qtype)
-		targetName = domain // 完全未命中，从原始域名开始查询
+	// 进程内规则未匹配时先查缓存，未命中才查询学习到的分类（分类缓存在 Redis 时需要一次往返）
+	if !matched {
+		if resp, ok := r.cachedBeforeLearned(ctx, req, startTime); ok {
+			return resp, nil
+		}
+		if groupName, matched = r.matcher.MatchLearned(domain); matched {
+			origin = category.OriginLearned
+		}
 	}
-
-	// 3. 匹配域名分组（使用原始查询域名，不是 CNAME 目标）
-	groupName, matched := r.matcher.Match(domain)
 	if !matched {
 		groupName = "unknown"
 	}
 
-	r.logger.LogCategoryMatch(ctx, domain, groupName, matched)
+	r.logger.LogCategoryMatch(ctx, domain, groupName, origin, matched)
 
-	// 4. 查找对应的策略
+	// 2. 查找对应的策略
 	policy := r.findPolicy(groupName)
 
 	r.logger.LogPolicyMatch(ctx, domain, policy.Name, policy.Group)
 
 	// 记录策略选项
-	if len(policy.Options.ExpectedIPs) > 0 || policy.Options.FallbackGroup != "" || policy.Options.DisableCache {
+	if len(policy.Options.ExpectedIPs) > 0 || policy.Options.FallbackGroup != "" || policy.Options.DisableCache ||
+		policy.Options.Strategy != "" || policy.Options.DisableIPv6 || policy.Options.ECS != "" {
 		options := make(map[string]interface{})
 		if len(policy.Options.ExpectedIPs) > 0 {
 			options["expected_ips"] = policy.Options.ExpectedIPs
@@ -107,21 +110,69 @@ func (r *Router) Route(ctx context.Context, domain string, qtype uint16) (*dns.M
 		if policy.Options.DisableCache {
 			options["disable_cache"] = true
 		}
+		if policy.Options.Strategy != "" {
+			options["strategy"] = policy.Options.Strategy
+		}
+		if policy.Options.DisableIPv6 {
+			options["disable_ipv6"] = true
+		}
+		if policy.Options.ECS != "" {
+			options["ecs"] = policy.Options.ECS
+		}
 		r.logger.LogPolicyOptions(ctx, domain, options)
 	}
 
-	// 5. 处理 block 策略
+	// 策略 ecs 选项覆盖上游组的 ECS 配置，client 模式使用客户端地址
+	ctx = upstream.WithECS(ctx, policy.Options.ECS)
+	ctx = upstream.WithClient(ctx, req.ClientIP, req.ClientSubnet)
+
+	// client 模式下不同客户端子网的应答可能不同，按子网隔离缓存
+	ctx = r.withCacheScope(ctx, policy)
+
+	// 3. 处理 block 策略
 	if policy.Group == "block" {
 		r.logger.LogBlock(ctx, domain, qtype, policy.Options.BlockType)
 		return r.handleBlock(ctx, domain, qtype, policy.Options.BlockType)
 	}
 
-	// 6. 处理 proxy_ecs_fallback 策略
-	if policy.Group == "proxy_ecs_fallback" {
-		return r.handleProxyECSFallbackV2(ctx, domain, qtype, cachedAnswers, policy, startTime)
+	// 4. 处理 IP 协议族策略（ipv4_only / ipv6_only / prefer_ipv4 / prefer_ipv6）
+	suppress, probeType := familyAction(policy.Options, qtype)
+	if suppress {
+		return r.handleFamilySuppressed(ctx, domain, qtype, policy, nil, startTime), nil
+	}
+	if probeType != 0 {
+		return r.resolvePreferred(ctx, domain, qtype, probeType, policy, startTime)
 	}
 
-	// 7. 普通查询（查询 CNAME 链的目标域名）
+	// 5. 解析
+	return r.resolve(ctx, domain, qtype, policy, startTime)
+}
+
+// resolve 按策略解析查询（缓存 -> 上游）
+func (r *Router) resolve(ctx context.Context, domain string, qtype uint16, policy *Policy, startTime time.Time) (*dns.Msg, error) {
+	// 1. 尝试从缓存解析 CNAME 链
+	cachedAnswers, needUpstream, targetName := cache.ResolveCNAMEChain(r.cacheFor(ctx), domain, qtype, 10)
+
+	if !needUpstream {
+		// 完全命中缓存
+		return r.cacheHitResponse(ctx, domain, qtype, cachedAnswers, startTime), nil
+	}
+
+	// 2. 部分缓存命中或完全未命中
+	if len(cachedAnswers) > 0 {
+		r.logger.Debug("CNAME链部分缓存命中: domain=%s cached_depth=%d target=%s",
+			domain, len(cachedAnswers), targetName)
+	} else {
+		r.logger.LogCacheMiss(ctx, domain, qtype)
+		targetName = domain // 完全未命中，从原始域名开始查询
+	}
+
+	// 3. 处理 race 组（如 proxy_ecs_fallback）
+	if group, ok := r.raceGroups[policy.Group]; ok {
+		return r.handleRaceGroup(ctx, domain, qtype, group, policy, startTime)
+	}
+
+	// 4. 普通查询（查询 CNAME 链的目标域名）
 	r.logger.Debug("执行普通查询: domain=%s target=%s group=%s", domain, targetName, policy.Group)
 	resp, err := r.upstreamMgr.Query(ctx, policy.Group, targetName, qtype)
 	if err != nil {
@@ -131,17 +182,17 @@ func (r *Router) Route(ctx context.Context, domain string, qtype uint16) (*dns.M
 		return nil, err
 	}
 
-	// 8. 合并缓存的 CNAME 链和新查询的结果
+	// 5. 合并缓存的 CNAME 链和新查询的结果
 	finalResp := r.mergeCNAMEChain(domain, qtype, cachedAnswers, resp)
 
 	r.logger.LogDNSAnswer(ctx, domain, finalResp.Answer)
 
-	// 8.5. 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
+	// 5.5. 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
 	if policy.Options.DisableHTTPS {
 		r.filterHTTPSRecords(ctx, finalResp)
 	}
 
-	// 9. 验证 expected_ips（如果配置了）
+	// 6. 验证 expected_ips（如果配置了）
 	if len(policy.Options.ExpectedIPs) > 0 {
 		finalResp, err = r.handleIPValidation(ctx, domain, qtype, targetName, finalResp, policy, cachedAnswers)
 		if err != nil {
@@ -149,7 +200,7 @@ func (r *Router) Route(ctx context.Context, domain string, qtype uint16) (*dns.M
 		}
 	}
 
-	// 10. 缓存结果（按 RR 记录分别缓存）
+	// 7. 缓存结果（按 RR 记录分别缓存）
 	if !policy.Options.DisableCache {
 		r.cacheResponse(ctx, domain, finalResp, 0)
 	}
@@ -160,20 +211,70 @@ func (r *Router) Route(ctx context.Context, domain string, qtype uint16) (*dns.M
 	return finalResp, nil
 }
 
+// cacheHitResponse 用完全命中缓存的记录构造应答
+func (r *Router) cacheHitResponse(ctx context.Context, domain string, qtype uint16, answers []dns.RR, startTime time.Time) *dns.Msg {
+	msg := cache.BuildResponseFromCache(domain, qtype, nil)
+	msg.Answer = answers
+	latency := time.Since(startTime)
+
+	r.logger.LogCacheHit(ctx, domain, qtype, time.Duration(answers[0].Header().Ttl)*time.Second)
+	r.logger.LogQueryComplete(ctx, domain, qtype, uint16(msg.Rcode), true, latency, "cache", len(msg.Answer))
+	return msg
+}
+
+// cachedBeforeLearned 进程内规则未匹配的域名按 unknown 策略的缓存范围查询缓存，完全命中时不再查询学习到的分类
+// 仅当 unknown 策略和 race 组可能学习到的分类对应的策略都会直接使用缓存（不是 block、不做协议族处理）时生效，
+// 这时无论域名学习到哪个分类，缓存命中的应答都相同
+func (r *Router) cachedBeforeLearned(ctx context.Context, req *Request, startTime time.Time) (*dns.Msg, bool) {
+	policy := r.policyOrDefault("unknown")
+	if !answersFromCache(policy, req.Qtype) {
+		return nil, false
+	}
+	for _, group := range r.raceGroups {
+		for _, name := range []string{group.MatchCategory, group.MissCategory} {
+			if name != "" && !answersFromCache(r.policyOrDefault(name), req.Qtype) {
+				return nil, false
+			}
+		}
+	}
+
+	ctx = upstream.WithECS(ctx, policy.Options.ECS)
+	ctx = upstream.WithClient(ctx, req.ClientIP, req.ClientSubnet)
+	ctx = r.withCacheScope(ctx, policy)
+
+	answers, needUpstream, _ := cache.ResolveCNAMEChain(r.cacheFor(ctx), req.Domain, req.Qtype, 10)
+	if needUpstream {
+		return nil, false
+	}
+	return r.cacheHitResponse(ctx, req.Domain, req.Qtype, answers, startTime), true
+}
+
+// answersFromCache 策略对该记录类型是否直接按缓存 -> 上游解析（不是 block，也不屏蔽或探测协议族）
+func answersFromCache(policy *Policy, qtype uint16) bool {
+	if policy.Group == "block" {
+		return false
+	}
+	suppress, probeType := familyAction(policy.Options, qtype)
+	return !suppress && probeType == 0
+}
+
 // findPolicy 查找策略
 func (r *Router) findPolicy(groupName string) *Policy {
+	policy := r.policyOrDefault(groupName)
+	if policy == r.defaultPolicy {
+		r.logger.Debug("使用默认策略: group=%s", config.DefaultRaceGroup)
+	}
+	return policy
+}
+
+// policyOrDefault 返回名称对应的策略，未配置时返回默认策略
+func (r *Router) policyOrDefault(name string) *Policy {
 	for _, p := range r.policies {
-		if p.Name == groupName {
+		if p.Name == name {
 			return p
 		}
 	}
-
-	// 使用默认策略
-	r.logger.Debug("使用默认策略: group=proxy_ecs_fallback")
-	return &Policy{
-		Name:  "unknown",
-		Group: "proxy_ecs_fallback",
-	}
+	return r.defaultPolicy
 }
 
 // mergeCNAMEChain 合并缓存的 CNAME 链和新查询的结果
@@ -191,6 +292,9 @@ func (r *Router) mergeCNAMEChain(qname string, qtype uint16, cachedAnswers []dns
 	// 再添加上游返回的答案
 	msg.Answer = append(msg.Answer, upstreamResp.Answer...)
 
+	// 保留授权部分（NODATA/NXDOMAIN 的 SOA 用于负缓存）
+	msg.Ns = upstreamResp.Ns
+
 	return msg
 }
 
@@ -230,12 +334,13 @@ func (r *Router) handleIPValidation(ctx context.Context, domain string, qtype ui
 			// 合并 CNAME 链
 			return r.mergeCNAMEChain(domain, qtype, cachedAnswers, fallbackResp), nil
 		} else {
-			// 回退到 proxy_ecs_fallback
+			// 回退到默认 race 组
 			r.logger.Debug("IP验证失败且无fallback_group，回退到unknown策略: domain=%s", domain)
-			return r.handleProxyECSFallbackV2(ctx, domain, qtype, nil, &Policy{
-				Name:  "unknown",
-				Group: "proxy_ecs_fallback",
-			}, time.Now())
+			group, ok := r.raceGroups[config.DefaultRaceGroup]
+			if !ok {
+				return nil, fmt.Errorf("race 组不存在: %s", config.DefaultRaceGroup)
+			}
+			return r.handleRaceGroup(ctx, domain, qtype, group, r.defaultPolicy, time.Now())
 		}
 	}
 
@@ -276,8 +381,9 @@ func (r *Router) cacheResponse(ctx context.Context, domain string, resp *dns.Msg
 	}
 
 	// 批量写入缓存
+	dnsCache := r.cacheFor(ctx)
 	for key, items := range grouped {
-		if err := r.dnsCache.SetRRs(key.name, key.qtype, items); err != nil {
+		if err := dnsCache.SetRRs(key.name, key.qtype, items); err != nil {
 			r.logger.Debug("缓存写入失败: qname=%s qtype=%d error=%v", key.name, key.qtype, err)
 		} else {
 			// 计算 TTL 用于日志
@@ -307,158 +413,10 @@ func (r *Router) handleBlock(ctx context.Context, domain string, qtype uint16, b
 	}
 }
 
-// handleProxyECSFallbackV2 处理 proxy_ecs_fallback 策略（支持 CNAME 链）
-func (r *Router) handleProxyECSFallbackV2(ctx context.Context, domain string, qtype uint16,
-	cachedAnswers []dns.RR, policy *Policy, startTime time.Time) (*dns.Msg, error) {
-
-	r.logger.LogProxyECSFallback(ctx, domain, "开始并发查询", map[string]interface{}{
-		"groups": []string{"proxy_ecs", "proxy"},
-	})
-
-	type result struct {
-		resp *dns.Msg
-		err  error
-		from string
-	}
-
-	resChan := make(chan result, 2)
-
-	// 并发查询 proxy_ecs 和 proxy
-	go func() {
-		resp, err := r.upstreamMgr.Query(ctx, "proxy_ecs", domain, qtype)
-		resChan <- result{resp: resp, err: err, from: "proxy_ecs"}
-	}()
-
-	go func() {
-		resp, err := r.upstreamMgr.Query(ctx, "proxy", domain, qtype)
-		resChan <- result{resp: resp, err: err, from: "proxy"}
-	}()
-
-	// 等待结果
-	var proxyECSResp *dns.Msg
-	var proxyResp *dns.Msg
-
-	timeout := time.After(3 * time.Second)
-	for i := 0; i < 2; i++ {
-		select {
-		case res := <-resChan:
-			if res.from == "proxy_ecs" {
-				proxyECSResp = res.resp
-				if res.err != nil {
-					r.logger.LogProxyECSFallback(ctx, domain, "proxy_ecs查询失败", map[string]interface{}{
-						"error": res.err.Error(),
-					})
-				} else {
-					r.logger.LogProxyECSFallback(ctx, domain, "proxy_ecs查询成功", map[string]interface{}{
-						"answer_count": len(proxyECSResp.Answer),
-					})
-				}
-			} else {
-				proxyResp = res.resp
-				if res.err != nil {
-					r.logger.LogProxyECSFallback(ctx, domain, "proxy查询失败", map[string]interface{}{
-						"error": res.err.Error(),
-					})
-				} else {
-					r.logger.LogProxyECSFallback(ctx, domain, "proxy查询成功", map[string]interface{}{
-						"answer_count": len(proxyResp.Answer),
-					})
-				}
-			}
-		case <-timeout:
-			r.logger.LogProxyECSFallback(ctx, domain, "查询超时", map[string]interface{}{
-				"timeout": "3s",
-			})
-			break
-		}
-	}
-
-	// 检查 proxy_ecs 结果是否匹配 fallback 规则
-	if proxyECSResp != nil {
-		ips := utils.ExtractIPs(proxyECSResp.Answer)
-		ipStrs := make([]string, len(ips))
-		for i, ip := range ips {
-			ipStrs[i] = ip.String()
-		}
-
-		r.logger.LogProxyECSFallback(ctx, domain, "判断是否需要fallback到direct", map[string]interface{}{
-			"ips": ipStrs,
-		})
-
-		for _, ip := range ips {
-			if r.geoipMatcher.MatchAny(ip, r.fallbackRules) {
-				r.logger.LogFallback(ctx, domain, "proxy_ecs", "direct", "执行fallback到direct")
-
-				directResp, err := r.upstreamMgr.Query(ctx, "direct", domain, qtype)
-				if err == nil {
-					// 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
-					if policy.Options.DisableHTTPS {
-						r.filterHTTPSRecords(ctx, directResp)
-					}
-
-					// 缓存结果
-					if !policy.Options.DisableCache {
-						r.cacheResponse(ctx, domain, directResp, 0)
-					}
-
-					// 异步写入域名分类缓存
-					go r.asyncCacheCategory(domain, "direct_site")
-
-					latency := time.Since(startTime)
-					r.logger.LogQueryComplete(ctx, domain, qtype, uint16(directResp.Rcode), false, latency, "direct", len(directResp.Answer))
-					return directResp, nil
-				}
-				return directResp, err
-			}
-		}
-	}
-
-	// 使用 proxy 结果
-	if proxyResp != nil {
-		// 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
-		if policy.Options.DisableHTTPS {
-			r.filterHTTPSRecords(ctx, proxyResp)
-		}
-
-		// 缓存结果
-		if !policy.Options.DisableCache {
-			r.cacheResponse(ctx, domain, proxyResp, 0)
-		}
-
-		go r.asyncCacheCategory(domain, "proxy_site")
-
-		latency := time.Since(startTime)
-		r.logger.LogQueryComplete(ctx, domain, qtype, uint16(proxyResp.Rcode), false, latency, "proxy", len(proxyResp.Answer))
-		return proxyResp, nil
-	}
-
-	// 使用 proxy_ecs 结果
-	if proxyECSResp != nil {
-		// 过滤 HTTPS/SVCB 记录（如果配置了 disable_https）
-		if policy.Options.DisableHTTPS {
-			r.filterHTTPSRecords(ctx, proxyECSResp)
-		}
-
-		// 缓存结果
-		if !policy.Options.DisableCache {
-			r.cacheResponse(ctx, domain, proxyECSResp, 0)
-		}
-
-		go r.asyncCacheCategory(domain, "proxy_site")
-
-		latency := time.Since(startTime)
-		r.logger.LogQueryComplete(ctx, domain, qtype, uint16(proxyECSResp.Rcode), false, latency, "proxy_ecs", len(proxyECSResp.Answer))
-		return proxyECSResp, nil
-	}
-
-	r.logger.LogError(ctx, "ProxyECSFallback全部失败", domain, fmt.Errorf("所有查询失败"), map[string]interface{}{})
-	return nil, fmt.Errorf("所有查询失败")
-}
-
-// asyncCacheCategory 异步写入域名分类缓存
-func (r *Router) asyncCacheCategory(domain, category string) {
+// asyncCacheCategory 异步写入域名分类缓存（evidence 为学习依据）
+func (r *Router) asyncCacheCategory(domain, category string, evidence *cache.Evidence) {
 	if r.categoryCache != nil {
-		err := r.categoryCache.Set(domain, category)
+		err := r.categoryCache.Set(domain, category, evidence)
 		if err != nil {
 			r.logger.Debug("写入域名分类缓存失败: domain=%s category=%s error=%v", domain, category, err)
 		} else {
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"violet-dns/cache"
+	"violet-dns/config"
+)
+
+// cacheScopeKey 缓存子网范围的 context key
+type cacheScopeKey struct{}
+
+// withCacheScope 根据策略确定本次查询的缓存子网范围并写入 context
+// 应答可能来自的任一上游组使用 client 模式 ECS 时按子网隔离（多个组的子网不同时组合为一个范围）
+func (r *Router) withCacheScope(ctx context.Context, policy *Policy) context.Context {
+	var subnets []string
+	for _, group := range r.answeringGroups(policy) {
+		subnet := r.upstreamMgr.ClientSubnet(ctx, group)
+		if subnet != "" && !slices.Contains(subnets, subnet) {
+			subnets = append(subnets, subnet)
+		}
+	}
+	if len(subnets) == 0 {
+		return ctx
+	}
+
+	scope := strings.Join(subnets, ",")
+	r.logger.Debug("按 ECS 子网隔离缓存: group=%s subnet=%s", policy.Group, scope)
+	return context.WithValue(ctx, cacheScopeKey{}, scope)
+}
+
+// answeringGroups 返回策略下可能给出应答的所有上游组：
+// race 组的 primary、secondary 和 fallback，expected_ips 验证失败时的 fallback_group 或默认 race 组
+func (r *Router) answeringGroups(policy *Policy) []string {
+	groups := r.expandRaceGroup(policy.Group)
+	if len(policy.Options.ExpectedIPs) > 0 {
+		if policy.Options.FallbackGroup != "" {
+			groups = append(groups, r.expandRaceGroup(policy.Options.FallbackGroup)...)
+		} else {
+			groups = append(groups, r.expandRaceGroup(config.DefaultRaceGroup)...)
+		}
+	}
+	return groups
+}
+
+// expandRaceGroup race 组展开为其引用的上游组，普通上游组原样返回
+func (r *Router) expandRaceGroup(name string) []string {
+	race, ok := r.raceGroups[name]
+	if !ok {
+		return []string{name}
+	}
+
+	groups := []string{race.Primary, race.Fallback}
+	if race.Secondary != "" {
+		groups = append(groups, race.Secondary)
+	}
+	return groups
+}
+
+// cacheFor 返回本次查询使用的 DNS 缓存（client 模式下为对应子网的缓存视图）
+func (r *Router) cacheFor(ctx context.Context) cache.DNSCache {
+	if subnet, ok := ctx.Value(cacheScopeKey{}).(string); ok {
+		return r.dnsCache.WithSubnet(subnet)
+	}
+	return r.dnsCache
+}
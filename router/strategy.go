@@ -0,0 +1,156 @@
+package router
+
+import (
+	"context"
+	"time"
+
+	"violet-dns/cache"
+	"violet-dns/config"
+	"violet-dns/utils"
+
+	"github.com/miekg/dns"
+)
+
+const defaultNoDataTTL = 60 // 上游未返回 SOA 时 NODATA 的缓存时间（秒）
+
+// familyAction 根据 strategy / disable_ipv6 判断查询的处理方式
+// 返回: 是否直接返回空 NOERROR, 需要并发探测的记录类型（0 表示不探测）
+func familyAction(opts config.QueryPolicyOptions, qtype uint16) (bool, uint16) {
+	switch qtype {
+	case dns.TypeA:
+		switch opts.Strategy {
+		case "ipv6_only":
+			return true, 0
+		case "prefer_ipv6":
+			if opts.DisableIPv6 {
+				return false, 0 // IPv6 已禁用，偏好无意义
+			}
+			return false, dns.TypeAAAA
+		}
+	case dns.TypeAAAA:
+		if opts.DisableIPv6 {
+			return true, 0
+		}
+		switch opts.Strategy {
+		case "ipv4_only":
+			return true, 0
+		case "prefer_ipv4":
+			return false, dns.TypeA
+		}
+	}
+	return false, 0
+}
+
+// handleFamilySuppressed 返回被策略屏蔽的协议族查询（空 NOERROR）
+// resolved 为已完成的解析结果时复用其应答（保留 CNAME 链和标志位，只去掉被屏蔽的记录类型），为 nil 时构造空应答
+func (r *Router) handleFamilySuppressed(ctx context.Context, domain string, qtype uint16, policy *Policy,
+	resolved *dns.Msg, startTime time.Time) *dns.Msg {
+
+	var resp *dns.Msg
+	if resolved != nil && resolved.Rcode == dns.RcodeSuccess {
+		resp = resolved.Copy()
+		resp.Answer = resp.Answer[:0]
+		for _, rr := range resolved.Answer {
+			if rr.Header().Rrtype != qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		resp.Ns = nil
+	} else {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(domain), qtype)
+		resp = utils.CreateNoErrorResponse(m)
+	}
+
+	r.logger.Debug("协议族策略屏蔽查询: domain=%s qtype=%s strategy=%s disable_ipv6=%v",
+		domain, dns.TypeToString[qtype], policy.Options.Strategy, policy.Options.DisableIPv6)
+
+	latency := time.Since(startTime)
+	r.logger.LogQueryComplete(ctx, domain, qtype, uint16(resp.Rcode), false, latency, "strategy", len(resp.Answer))
+	return resp
+}
+
+// resolvePreferred 处理 prefer_ipv4 / prefer_ipv6：
+// 解析请求的记录类型的同时并发探测偏好协议族，偏好协议族有记录时去掉解析结果中请求类型的记录后返回
+func (r *Router) resolvePreferred(ctx context.Context, domain string, qtype, probeType uint16,
+	policy *Policy, startTime time.Time) (*dns.Msg, error) {
+
+	probeChan := make(chan bool, 1)
+	go func() {
+		probeChan <- r.probeFamily(ctx, domain, probeType, policy)
+	}()
+
+	resp, err := r.resolve(ctx, domain, qtype, policy, startTime)
+	hasPreferred := <-probeChan
+
+	if hasPreferred {
+		r.logger.Debug("偏好协议族存在记录，屏蔽当前查询: domain=%s qtype=%s preferred=%s",
+			domain, dns.TypeToString[qtype], dns.TypeToString[probeType])
+		if err != nil {
+			resp = nil
+		}
+		return r.handleFamilySuppressed(ctx, domain, qtype, policy, resp, startTime), nil
+	}
+
+	return resp, err
+}
+
+// probeFamily 探测域名是否存在指定类型的记录（优先使用缓存，结果写入缓存）
+func (r *Router) probeFamily(ctx context.Context, domain string, probeType uint16, policy *Policy) bool {
+	// 1. 正向缓存
+	dnsCache := r.cacheFor(ctx)
+	answers, needUpstream, _ := cache.ResolveCNAMEChain(dnsCache, domain, probeType, 10)
+	if !needUpstream {
+		return hasRecordType(answers, probeType)
+	}
+
+	// 2. NODATA 缓存
+	if dnsCache.IsNoData(domain, probeType) {
+		r.logger.Debug("探测命中 NODATA 缓存: domain=%s qtype=%s", domain, dns.TypeToString[probeType])
+		return false
+	}
+
+	// 3. 按同一策略查询上游（正向结果由 resolve 写入缓存）
+	resp, err := r.resolve(ctx, domain, probeType, policy, time.Now())
+	if err != nil {
+		r.logger.Debug("协议族探测失败: domain=%s qtype=%s error=%v", domain, dns.TypeToString[probeType], err)
+		return false
+	}
+
+	if hasRecordType(resp.Answer, probeType) {
+		return true
+	}
+
+	// 4. 缓存 NODATA 结果
+	if resp.Rcode == dns.RcodeSuccess && !policy.Options.DisableCache {
+		if err := dnsCache.SetNoData(domain, probeType, noDataTTL(resp)); err != nil {
+			r.logger.Debug("NODATA 缓存写入失败: domain=%s qtype=%s error=%v", domain, dns.TypeToString[probeType], err)
+		}
+	}
+
+	return false
+}
+
+// hasRecordType 检查应答中是否包含指定类型的记录
+func hasRecordType(answers []dns.RR, rrtype uint16) bool {
+	for _, rr := range answers {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// noDataTTL 根据 SOA 计算 NODATA 缓存时间（RFC 2308）
+func noDataTTL(resp *dns.Msg) uint32 {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return ttl
+		}
+	}
+	return defaultNoDataTTL
+}
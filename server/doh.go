@@ -0,0 +1,380 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"violet-dns/middleware"
+)
+
+const (
+	dohContentType  = "application/dns-message"
+	dohJSONType     = "application/dns-json"
+	dohReadTimeout  = 10 * time.Second
+	dohWriteTimeout = 10 * time.Second
+)
+
+// errDoHContentType POST 请求的 Content-Type 不是 application/dns-message（返回 415）
+var errDoHContentType = errors.New("unsupported content type")
+
+// dohServer DNS-over-HTTPS 服务（RFC 8484 + JSON API）
+type dohServer struct {
+	server         *http.Server
+	ln             net.Listener
+	path           string
+	tls            bool
+	trustedProxies []*net.IPNet
+	handler        dns.HandlerFunc
+	logger         *middleware.Logger
+}
+
+// newDoHServer 创建 DoH 服务，tlsConfig 为 nil 时使用明文 HTTP
+func newDoHServer(ln net.Listener, path string, tlsConfig *tls.Config, trustedProxies []string,
+	handler dns.HandlerFunc, logger *middleware.Logger) *dohServer {
+
+	s := &dohServer{
+		ln:      ln,
+		path:    path,
+		tls:     tlsConfig != nil,
+		handler: handler,
+		logger:  logger,
+	}
+
+	for _, cidr := range trustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			s.trustedProxies = append(s.trustedProxies, ipNet)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.serveHTTP)
+
+	s.server = &http.Server{
+		Handler:      mux,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  dohReadTimeout,
+		WriteTimeout: dohWriteTimeout,
+	}
+
+	return s
+}
+
+// serve 启动 HTTP 服务
+func (s *dohServer) serve() error {
+	var err error
+	if s.tls {
+		// ServeTLS 会使用 TLSConfig.GetCertificate 并自动启用 HTTP/2
+		err = s.server.ServeTLS(s.ln, "", "")
+	} else {
+		err = s.server.Serve(s.ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("DoH 服务错误: %w", err)
+	}
+	return nil
+}
+
+// shutdown 关闭 HTTP 服务
+func (s *dohServer) shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// close 关闭未启动的监听器
+func (s *dohServer) close() error {
+	return s.ln.Close()
+}
+
+// serveHTTP 处理 DoH 请求
+func (s *dohServer) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	// JSON API: GET ?name=example.com&type=A
+	if r.Method == http.MethodGet && r.URL.Query().Get("name") != "" {
+		s.serveJSON(rw, r)
+		return
+	}
+
+	req, err := s.parseWireRequest(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errDoHContentType) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(rw, err.Error(), status)
+		return
+	}
+
+	resp := s.exchange(r, req)
+	if resp == nil {
+		http.Error(rw, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	packed, err := resp.Pack()
+	if err != nil {
+		http.Error(rw, "pack response failed", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", dohContentType)
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(resp)))
+	rw.Write(packed)
+}
+
+// parseWireRequest 解析 RFC 8484 请求（GET ?dns= 或 POST）
+func (s *dohServer) parseWireRequest(r *http.Request) (*dns.Msg, error) {
+	var buf []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			return nil, fmt.Errorf("missing dns parameter")
+		}
+		buf, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns parameter: %w", err)
+		}
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dohContentType {
+			return nil, fmt.Errorf("%w: %s", errDoHContentType, ct)
+		}
+		buf, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		if err != nil {
+			return nil, fmt.Errorf("read body failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported method: %s", r.Method)
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("invalid dns message: %w", err)
+	}
+	if len(req.Question) == 0 {
+		return nil, fmt.Errorf("empty question")
+	}
+	return req, nil
+}
+
+// exchange 将请求交给统一的查询处理流程，返回写入的响应
+func (s *dohServer) exchange(r *http.Request, req *dns.Msg) *dns.Msg {
+	w := &httpResponseWriter{
+		remote: &net.TCPAddr{IP: s.clientIP(r)},
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		w.local = local
+	}
+
+	s.handler(w, req)
+	return w.msg
+}
+
+// clientIP 获取客户端 IP，来自受信任代理的请求使用 X-Forwarded-For
+func (s *dohServer) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.isTrusted(ip) {
+		return ip
+	}
+
+	// 从右向左跳过受信任代理，第一个不受信任的地址即为真实客户端
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !s.isTrusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrusted 判断 IP 是否属于受信任代理
+func (s *dohServer) isTrusted(ip net.IP) bool {
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonQuestion JSON API 问题
+type jsonQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// jsonRR JSON API 资源记录
+type jsonRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// jsonResponse JSON API 响应（与 Google/Cloudflare 格式兼容）
+type jsonResponse struct {
+	Status    int            `json:"Status"`
+	TC        bool           `json:"TC"`
+	RD        bool           `json:"RD"`
+	RA        bool           `json:"RA"`
+	AD        bool           `json:"AD"`
+	CD        bool           `json:"CD"`
+	Question  []jsonQuestion `json:"Question"`
+	Answer    []jsonRR       `json:"Answer,omitempty"`
+	Authority []jsonRR       `json:"Authority,omitempty"`
+}
+
+// serveJSON 处理 JSON API 请求
+func (s *dohServer) serveJSON(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("name")
+
+	qtype := dns.TypeA
+	if t := query.Get("type"); t != "" {
+		if n, err := strconv.ParseUint(t, 10, 16); err == nil {
+			qtype = uint16(n)
+		} else if v, ok := dns.StringToType[strings.ToUpper(t)]; ok {
+			qtype = v
+		} else {
+			http.Error(rw, "invalid type", http.StatusBadRequest)
+			return
+		}
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	req.CheckingDisabled = query.Get("cd") == "1" || query.Get("cd") == "true"
+	if do := query.Get("do"); do == "1" || do == "true" {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	resp := s.exchange(r, req)
+	if resp == nil {
+		http.Error(rw, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	out := jsonResponse{
+		Status: resp.Rcode,
+		TC:     resp.Truncated,
+		RD:     resp.RecursionDesired,
+		RA:     resp.RecursionAvailable,
+		AD:     resp.AuthenticatedData,
+		CD:     resp.CheckingDisabled,
+	}
+	for _, q := range resp.Question {
+		out.Question = append(out.Question, jsonQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	out.Answer = toJSONRRs(resp.Answer)
+	out.Authority = toJSONRRs(resp.Ns)
+
+	rw.Header().Set("Content-Type", dohJSONType)
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(resp)))
+	if err := json.NewEncoder(rw).Encode(out); err != nil {
+		s.logger.Debug("写入 DoH JSON 响应失败: %v", err)
+	}
+}
+
+// toJSONRRs 转换 RR 列表为 JSON 格式
+func toJSONRRs(rrs []dns.RR) []jsonRR {
+	result := make([]jsonRR, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		data := strings.TrimPrefix(rr.String(), hdr.String())
+		result = append(result, jsonRR{
+			Name: hdr.Name,
+			Type: hdr.Rrtype,
+			TTL:  hdr.Ttl,
+			Data: strings.TrimSpace(data),
+		})
+	}
+	return result
+}
+
+// minTTL 计算响应中的最小 TTL（用于 HTTP 缓存）
+func minTTL(msg *dns.Msg) uint32 {
+	var ttl uint32
+	found := false
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+	return ttl
+}
+
+// httpResponseWriter 捕获响应的 dns.ResponseWriter 实现
+type httpResponseWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+// transport 返回传输协议
+func (w *httpResponseWriter) transport() string {
+	return "https"
+}
+
+// LocalAddr 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) LocalAddr() net.Addr {
+	return w.local
+}
+
+// RemoteAddr 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+// WriteMsg 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+// Write 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+// Close 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) Close() error {
+	return nil
+}
+
+// TsigStatus 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *httpResponseWriter) TsigStatus() error {
+	return nil
+}
+
+// TsigTimersOnly 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *httpResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack 实现 dns.ResponseWriter 接口
+func (w *httpResponseWriter) Hijack() {}
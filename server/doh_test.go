@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/miekg/dns"
+	"violet-dns/config"
+)
+
+// newTestDoHServer 创建使用 stubRouter 的明文 DoH 服务（只使用其 HTTP 处理函数）
+func newTestDoHServer(trustedProxies []string) (*dohServer, *stubRouter) {
+	s, r := newTestServer(config.ServerConfig{})
+	return newDoHServer(nil, defaultDoHPath, nil, trustedProxies, s.handleQuery, s.logger), r
+}
+
+// packQuery 打包一条 A 查询
+func packQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	m.Id = id
+	packed, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func TestDoHWireFormat(t *testing.T) {
+	doh, _ := newTestDoHServer(nil)
+	ts := httptest.NewServer(http.HandlerFunc(doh.serveHTTP))
+	defer ts.Close()
+
+	query := packQuery(t, 0, "example.com")
+	tests := []struct {
+		name string
+		do   func() (*http.Response, error)
+	}{
+		{"GET base64url", func() (*http.Response, error) {
+			return http.Get(ts.URL + "?dns=" + base64.RawURLEncoding.EncodeToString(query))
+		}},
+		{"GET 带填充", func() (*http.Response, error) {
+			return http.Get(ts.URL + "?dns=" + base64.URLEncoding.EncodeToString(query))
+		}},
+		{"POST", func() (*http.Response, error) {
+			return http.Post(ts.URL, dohContentType, bytes.NewReader(query))
+		}},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.do()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: 状态码 %d, 期望 200: %s", tt.name, resp.StatusCode, body)
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != dohContentType {
+			t.Errorf("%s: Content-Type = %s, 期望 %s", tt.name, ct, dohContentType)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != "max-age=60" {
+			t.Errorf("%s: Cache-Control = %s, 期望 max-age=60", tt.name, cc)
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(body); err != nil {
+			t.Errorf("%s: 解析响应失败: %v", tt.name, err)
+			continue
+		}
+		if m.Id != 0 || len(m.Answer) != 1 || m.Question[0].Name != "example.com." {
+			t.Errorf("%s: 响应 id=%d answers=%d", tt.name, m.Id, len(m.Answer))
+		}
+	}
+}
+
+func TestDoHJSON(t *testing.T) {
+	doh, _ := newTestDoHServer(nil)
+
+	tests := []struct {
+		query  string
+		status int
+		qtype  uint16
+	}{
+		{"name=example.com", http.StatusOK, dns.TypeA},
+		{"name=example.com&type=A", http.StatusOK, dns.TypeA},
+		{"name=example.com&type=aaaa", http.StatusOK, dns.TypeAAAA},
+		{"name=example.com&type=28", http.StatusOK, dns.TypeAAAA},
+		{"name=example.com&type=bogus", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		doh.serveHTTP(rec, httptest.NewRequest(http.MethodGet, defaultDoHPath+"?"+tt.query, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: 状态码 %d, 期望 %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != dohJSONType {
+			t.Errorf("%s: Content-Type = %s, 期望 %s", tt.query, ct, dohJSONType)
+		}
+
+		var out jsonResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: 解析 JSON 失败: %v", tt.query, err)
+		}
+		if out.Status != dns.RcodeSuccess || len(out.Question) != 1 || out.Question[0].Type != tt.qtype {
+			t.Errorf("%s: 响应 %+v", tt.query, out)
+		}
+		if len(out.Answer) != 1 || out.Answer[0].Data != "192.0.2.1" || out.Answer[0].TTL != 60 {
+			t.Errorf("%s: Answer = %+v", tt.query, out.Answer)
+		}
+	}
+}
+
+func TestDoHErrors(t *testing.T) {
+	doh, _ := newTestDoHServer(nil)
+	query := packQuery(t, 0, "example.com")
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"GET 缺少 dns 参数", http.MethodGet, defaultDoHPath, "", nil, http.StatusBadRequest},
+		{"GET base64 无效", http.MethodGet, defaultDoHPath + "?dns=!!!", "", nil, http.StatusBadRequest},
+		{"GET 消息无效", http.MethodGet, defaultDoHPath + "?dns=" + base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3}), "", nil, http.StatusBadRequest},
+		{"POST Content-Type 错误", http.MethodPost, defaultDoHPath, "application/json", query, http.StatusUnsupportedMediaType},
+		{"POST 缺少 Content-Type", http.MethodPost, defaultDoHPath, "", query, http.StatusUnsupportedMediaType},
+		{"POST 消息无效", http.MethodPost, defaultDoHPath, dohContentType, []byte("not dns"), http.StatusBadRequest},
+		{"POST 没有问题", http.MethodPost, defaultDoHPath, dohContentType, query[:12], http.StatusBadRequest},
+		{"不支持的方法", http.MethodPut, defaultDoHPath, dohContentType, query, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		doh.serveHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: 状态码 %d, 期望 %d: %s", tt.name, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+}
+
+func TestDoHClientIP(t *testing.T) {
+	doh, r := newTestDoHServer([]string{"10.0.0.0/8", "fd00::/8", "invalid"})
+	query := base64.RawURLEncoding.EncodeToString(packQuery(t, 0, "example.com"))
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string
+		want      string
+	}{
+		{"不受信任的对端伪造请求头", "203.0.113.5:4000", "1.2.3.4", "203.0.113.5"},
+		{"受信任代理", "10.0.0.1:4000", "1.2.3.4", "1.2.3.4"},
+		{"受信任代理没有请求头", "10.0.0.1:4000", "", "10.0.0.1"},
+		{"客户端伪造最左侧地址", "10.0.0.1:4000", "6.6.6.6, 1.2.3.4, 10.0.0.2", "1.2.3.4"},
+		{"全部是受信任代理", "10.0.0.1:4000", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"右侧地址无效", "10.0.0.1:4000", "1.2.3.4, garbage", "10.0.0.1"},
+		{"IPv6 受信任代理", "[fd00::1]:4000", "2001:db8::1", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, defaultDoHPath+"?dns="+url.QueryEscape(query), nil)
+		req.RemoteAddr = tt.peer
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		rec := httptest.NewRecorder()
+		doh.serveHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: 状态码 %d", tt.name, rec.Code)
+		}
+
+		got := r.lastRequest()
+		if got.ClientIP.String() != tt.want {
+			t.Errorf("%s: 客户端 IP = %s, 期望 %s", tt.name, got.ClientIP, tt.want)
+		}
+		if got.Transport != "https" {
+			t.Errorf("%s: transport = %s, 期望 https", tt.name, got.Transport)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"violet-dns/middleware"
+)
+
+// DoQ 错误码（RFC 9250 4.3）
+const (
+	doqNoError       quic.ApplicationErrorCode = 0x0
+	doqInternalError quic.ApplicationErrorCode = 0x1
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+)
+
+// errDoQProtocol 违反 RFC 9250 的查询（关闭整个连接）
+var errDoQProtocol = errors.New("DoQ 协议错误")
+
+const doqStreamTimeout = 10 * time.Second // 单个流读写超时
+
+// doqServer DNS-over-QUIC 服务（RFC 9250），每个双向流对应一条查询
+type doqServer struct {
+	ln      *quic.EarlyListener
+	handler dns.HandlerFunc
+	logger  *middleware.Logger
+
+	mu      sync.Mutex
+	conns   map[*quic.Conn]struct{}
+	closing bool
+	connsWg sync.WaitGroup
+	streams sync.WaitGroup // 正在处理的查询
+}
+
+// newDoQServer 创建 DoQ 服务并开始监听
+// allow0RTT 为 true 时接受 0-RTT 数据：省去一次握手往返，但 0-RTT 中的查询可以被
+// 在线路上截获后重放（RFC 9250 4.5），服务端无法区分，默认关闭
+func newDoQServer(addr string, tlsConfig *tls.Config, idleTimeout time.Duration, allow0RTT bool,
+	handler dns.HandlerFunc, logger *middleware.Logger) (*doqServer, error) {
+
+	ln, err := quic.ListenAddrEarly(addr, tlsConfig, &quic.Config{
+		MaxIdleTimeout:     idleTimeout,
+		MaxIncomingStreams: maxPipelinedQueries,
+		Allow0RTT:          allow0RTT,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &doqServer{
+		ln:      ln,
+		handler: handler,
+		logger:  logger,
+		conns:   make(map[*quic.Conn]struct{}),
+	}, nil
+}
+
+// serve 接受 QUIC 连接，直到监听器关闭
+func (s *doqServer) serve() error {
+	for {
+		conn, err := s.ln.Accept(context.Background())
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			return fmt.Errorf("DoQ accept 失败: %w", err)
+		}
+
+		if !s.trackConn(conn) {
+			conn.CloseWithError(doqNoError, "")
+			return nil
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// trackConn 记录活跃连接，正在关闭时返回 false
+func (s *doqServer) trackConn(conn *quic.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.connsWg.Add(1)
+	return true
+}
+
+// untrackConn 移除连接记录
+func (s *doqServer) untrackConn(conn *quic.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.connsWg.Done()
+}
+
+// isClosing 是否正在关闭
+func (s *doqServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// serveConn 处理单个连接上的所有流
+func (s *doqServer) serveConn(conn *quic.Conn) {
+	defer s.untrackConn(conn)
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			// 连接关闭或空闲超时
+			return
+		}
+
+		s.streams.Add(1)
+		go func() {
+			defer s.streams.Done()
+			s.serveStream(conn, stream)
+		}()
+	}
+}
+
+// serveStream 处理单个流上的查询
+func (s *doqServer) serveStream(conn *quic.Conn, stream *quic.Stream) {
+	stream.SetDeadline(time.Now().Add(doqStreamTimeout))
+
+	req, err := readDoQQuery(stream)
+	if err != nil {
+		s.logger.Debug("读取 DoQ 查询失败: client=%s error=%v", conn.RemoteAddr(), err)
+		if errors.Is(err, errDoQProtocol) {
+			// RFC 9250 4.3.3: 协议错误是致命错误，以 DOQ_PROTOCOL_ERROR 关闭整个连接
+			conn.CloseWithError(doqProtocolError, err.Error())
+			return
+		}
+		// 流被客户端重置或超时：停止读取（STOP_SENDING）并放弃响应
+		stream.CancelRead(quic.StreamErrorCode(doqInternalError))
+		stream.CancelWrite(quic.StreamErrorCode(doqInternalError))
+		return
+	}
+
+	w := &quicResponseWriter{conn: conn, stream: stream}
+	s.handler(w, req)
+
+	if !w.written {
+		stream.CancelWrite(quic.StreamErrorCode(doqInternalError))
+		return
+	}
+	// 关闭发送方向（FIN），告知客户端响应结束
+	stream.Close()
+}
+
+// readDoQQuery 读取流上的唯一一条查询（RFC 9250 4.2）
+// 客户端发送查询后必须以 FIN 结束发送方向，因此读取到 FIN 为止：
+// FIN 早于完整消息、一个流上有多条查询、Message ID 不为 0 都返回 errDoQProtocol
+func readDoQQuery(r io.Reader) (*dns.Msg, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, 2+dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("%w: 缺少长度前缀", errDoQProtocol)
+	}
+
+	length := int(binary.BigEndian.Uint16(buf))
+	if body := len(buf) - 2; body < length {
+		return nil, fmt.Errorf("%w: 消息不完整 (%d/%d 字节)", errDoQProtocol, body, length)
+	} else if body > length {
+		return nil, fmt.Errorf("%w: 流上有多余的数据", errDoQProtocol)
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(buf[2:]); err != nil {
+		return nil, fmt.Errorf("%w: 解析 DNS 消息失败: %v", errDoQProtocol, err)
+	}
+	// RFC 9250 4.2.1: Message ID 必须为 0
+	if req.Id != 0 {
+		return nil, fmt.Errorf("%w: message id 必须为 0", errDoQProtocol)
+	}
+	return req, nil
+}
+
+// shutdown 停止接受新连接，等待正在处理的查询完成后关闭所有连接
+func (s *doqServer) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.CloseWithError(doqNoError, "server shutdown")
+	}
+	s.mu.Unlock()
+	s.connsWg.Wait()
+
+	return err
+}
+
+// close 关闭未启动的监听器
+func (s *doqServer) close() error {
+	return s.ln.Close()
+}
+
+// quicResponseWriter DoQ 流的 dns.ResponseWriter 实现
+type quicResponseWriter struct {
+	conn    *quic.Conn
+	stream  *quic.Stream
+	written bool
+}
+
+// transport 返回传输协议
+func (w *quicResponseWriter) transport() string {
+	return "quic"
+}
+
+// LocalAddr 实现 dns.ResponseWriter 接口
+func (w *quicResponseWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+// RemoteAddr 实现 dns.ResponseWriter 接口
+func (w *quicResponseWriter) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+// WriteMsg 实现 dns.ResponseWriter 接口
+func (w *quicResponseWriter) WriteMsg(m *dns.Msg) error {
+	// RFC 9250 4.2.1: 响应的 Message ID 同样为 0
+	m.Id = 0
+	packed, err := m.Pack()
+	if err != nil {
+		return fmt.Errorf("打包 DNS 响应失败: %w", err)
+	}
+	_, err = w.Write(packed)
+	return err
+}
+
+// Write 实现 dns.ResponseWriter 接口（自动添加长度前缀）
+func (w *quicResponseWriter) Write(b []byte) (int, error) {
+	if len(b) > dns.MaxMsgSize {
+		return 0, fmt.Errorf("响应过大: %d 字节", len(b))
+	}
+
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+
+	if _, err := w.stream.Write(buf); err != nil {
+		return 0, err
+	}
+	w.written = true
+	return len(b), nil
+}
+
+// Close 实现 dns.ResponseWriter 接口
+func (w *quicResponseWriter) Close() error {
+	return w.stream.Close()
+}
+
+// TsigStatus 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *quicResponseWriter) TsigStatus() error {
+	return nil
+}
+
+// TsigTimersOnly 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *quicResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack 实现 dns.ResponseWriter 接口
+func (w *quicResponseWriter) Hijack() {}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"violet-dns/config"
+)
+
+// startDoQServer 在回环地址上启动 DoQ 服务，测试结束时关闭
+func startDoQServer(t *testing.T, allow0RTT bool) string {
+	t.Helper()
+	s, _ := newTestServer(config.ServerConfig{})
+	pair := writeTestCert(t, t.TempDir(), "doq", time.Now())
+	certs, err := newCertReloader(pair.certFile, pair.keyFile, s.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := newDoQServer("127.0.0.1:0", certs.tlsConfig("doq"), time.Second, allow0RTT, s.handleQuery, s.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.shutdown(ctx)
+	})
+	return srv.ln.Addr().String()
+}
+
+// doqClientTLS 测试客户端 TLS 配置
+func doqClientTLS() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"doq"}}
+}
+
+// dialDoQ 建立到测试服务的 QUIC 连接
+func dialDoQ(t *testing.T, addr string) *quic.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, addr, doqClientTLS(), nil)
+	if err != nil {
+		t.Fatalf("DoQ 握手失败: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseWithError(doqNoError, "") })
+	return conn
+}
+
+// doqExchange 在新流上写入 payload 并以 FIN 结束，返回流上读到的全部响应数据
+func doqExchange(t *testing.T, conn *quic.Conn, payload []byte) ([]byte, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := stream.Write(payload); err != nil {
+		return nil, err
+	}
+	stream.Close()
+	return io.ReadAll(stream)
+}
+
+// expectDoQProtocolError 检查连接被服务端以 DOQ_PROTOCOL_ERROR 关闭
+func expectDoQProtocolError(t *testing.T, conn *quic.Conn, err error) {
+	t.Helper()
+	if err == nil {
+		select {
+		case <-conn.Context().Done():
+			err = context.Cause(conn.Context())
+		case <-time.After(2 * time.Second):
+			t.Fatal("协议错误后连接没有关闭")
+		}
+	}
+
+	var appErr *quic.ApplicationError
+	if !errors.As(err, &appErr) || !appErr.Remote || appErr.ErrorCode != doqProtocolError {
+		t.Fatalf("错误 %v, 期望服务端以 DOQ_PROTOCOL_ERROR 关闭连接", err)
+	}
+}
+
+func TestDoQQuery(t *testing.T) {
+	addr := startDoQServer(t, false)
+	conn := dialDoQ(t, addr)
+
+	// 同一连接上的每条查询使用独立的流
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		data, err := doqExchange(t, conn, packTCPQuery(t, 0, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(data) < 2 || int(binary.BigEndian.Uint16(data)) != len(data)-2 {
+			t.Fatalf("%s: 响应长度前缀与消息长度不一致", name)
+		}
+
+		resp := new(dns.Msg)
+		if err := resp.Unpack(data[2:]); err != nil {
+			t.Fatalf("%s: 解析响应失败: %v", name, err)
+		}
+		if resp.Id != 0 || len(resp.Answer) != 1 || resp.Question[0].Name != dns.Fqdn(name) {
+			t.Errorf("%s: 响应 id=%d answers=%d", name, resp.Id, len(resp.Answer))
+		}
+	}
+}
+
+func TestDoQProtocolErrors(t *testing.T) {
+	addr := startDoQServer(t, false)
+	query := packTCPQuery(t, 0, "example.com")
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"Message ID 不为 0", packTCPQuery(t, 1234, "example.com")},
+		{"一个流上两条查询", append(append([]byte{}, query...), query...)},
+		{"FIN 早于完整消息", query[:len(query)-3]},
+		{"只有 FIN", nil},
+		{"消息无法解析", []byte{0, 13, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialDoQ(t, addr)
+			_, err := doqExchange(t, conn, tt.payload)
+			expectDoQProtocolError(t, conn, err)
+		})
+	}
+}
+
+func TestReadDoQQuery(t *testing.T) {
+	query := packTCPQuery(t, 0, "example.com")
+	if m, err := readDoQQuery(bytes.NewReader(query)); err != nil || m.Question[0].Name != "example.com." {
+		t.Fatalf("readDoQQuery = %v, %v", m, err)
+	}
+
+	// 读取失败（流被重置、超时）不是协议错误
+	readErr := errors.New("stream reset")
+	if _, err := readDoQQuery(io.MultiReader(bytes.NewReader(query[:4]), errReader{readErr})); !errors.Is(err, readErr) || errors.Is(err, errDoQProtocol) {
+		t.Errorf("读取失败时错误 = %v, 期望原始错误", err)
+	}
+
+	for _, payload := range [][]byte{nil, query[:1], query[:10], append(query, 0)} {
+		if _, err := readDoQQuery(bytes.NewReader(payload)); !errors.Is(err, errDoQProtocol) {
+			t.Errorf("readDoQQuery(%d 字节) 错误 = %v, 期望协议错误", len(payload), err)
+		}
+	}
+}
+
+func TestDoQ0RTT(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		addr := startDoQServer(t, allow)
+		tlsConf := doqClientTLS()
+		tlsConf.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+		// 第一次连接获取会话票据
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		first, err := quic.DialAddr(ctx, addr, tlsConf, nil)
+		if err != nil {
+			cancel()
+			t.Fatal(err)
+		}
+		if _, err := doqExchange(t, first, packTCPQuery(t, 0, "example.com")); err != nil {
+			cancel()
+			t.Fatal(err)
+		}
+		first.CloseWithError(doqNoError, "")
+
+		// 恢复会话时尝试 0-RTT，只有配置允许时服务端才接受
+		early, err := quic.DialAddrEarly(ctx, addr, tlsConf, nil)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := doqExchange(t, early, packTCPQuery(t, 0, "example.com"))
+		if err != nil || len(data) == 0 {
+			t.Fatalf("allow_0rtt=%v: 查询失败: %v", allow, err)
+		}
+		if used := early.ConnectionState().Used0RTT; used != allow {
+			t.Errorf("allow_0rtt=%v: Used0RTT = %v", allow, used)
+		}
+		early.CloseWithError(doqNoError, "")
+	}
+}
+
+// errReader 总是返回指定错误的 io.Reader
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
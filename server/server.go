@@ -2,60 +2,213 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
+	"violet-dns/config"
 	"violet-dns/middleware"
 	"violet-dns/router"
 )
 
+const (
+	defaultTCPIdleTimeout = 10 * time.Second // TCP 连接默认空闲超时
+	shutdownTimeout       = 5 * time.Second  // 关闭时等待正在处理的查询的最长时间
+	queryTimeout          = 10 * time.Second // 单个查询的最长处理时间（超时后返回 SERVFAIL）
+	defaultDoTPort        = 853
+	defaultDoHPort        = 443
+	defaultDoHPath        = "/dns-query"
+	defaultDoQPort        = 853
+)
+
 // Server DNS 服务器
 type Server struct {
-	port   int
-	bind   string
-	router router.QueryRouter // 使用接口而非具体类型
-	logger *middleware.Logger
+	port        int
+	bind        string
+	protocol    string
+	idleTimeout time.Duration
+	tls         config.TLSConfig
+	dot         config.DoTConfig
+	doh         config.DoHConfig
+	doq         config.DoQConfig
+	router      router.QueryRouter // 使用接口而非具体类型
+	logger      *middleware.Logger
 }
 
 // NewServer 创建新的 DNS 服务器
-func NewServer(port int, bind string, r router.QueryRouter, logger *middleware.Logger) *Server {
+func NewServer(cfg config.ServerConfig, r router.QueryRouter, logger *middleware.Logger) *Server {
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = "udp" // 默认仅监听 UDP
+	}
+
+	idleTimeout := time.Duration(cfg.TCPIdleTimeout) * time.Second
+	if idleTimeout == 0 {
+		idleTimeout = defaultTCPIdleTimeout
+	}
+
+	if cfg.DoT.Port == 0 {
+		cfg.DoT.Port = defaultDoTPort
+	}
+	if cfg.DoH.Port == 0 {
+		cfg.DoH.Port = defaultDoHPort
+	}
+	if cfg.DoQ.Port == 0 {
+		cfg.DoQ.Port = defaultDoQPort
+	}
+	if cfg.DoH.Path == "" {
+		cfg.DoH.Path = defaultDoHPath
+	}
+
 	return &Server{
-		port:   port,
-		bind:   bind,
-		router: r,
-		logger: logger,
+		port:        cfg.Port,
+		bind:        cfg.Bind,
+		protocol:    protocol,
+		idleTimeout: idleTimeout,
+		tls:         cfg.TLS,
+		dot:         cfg.DoT,
+		doh:         cfg.DoH,
+		doq:         cfg.DoQ,
+		router:      r,
+		logger:      logger,
 	}
 }
 
-// Start 启动服务器
+// listener 单个监听器（UDP/TCP 等）
+type listener interface {
+	// serve 阻塞运行，直到出错或被 shutdown
+	serve() error
+	// shutdown 停止监听并等待正在处理的查询完成
+	shutdown(ctx context.Context) error
+	// close 关闭尚未启动的监听器，释放已绑定的端口
+	close() error
+}
+
+// Start 启动服务器，阻塞直到上下文取消或任一监听器出错
 func (s *Server) Start(ctx context.Context) error {
-	// 创建 DNS 处理器
-	dns.HandleFunc(".", s.handleQuery)
+	listeners, err := s.listen(ctx)
+	if err != nil {
+		return err
+	}
 
-	// 启动 UDP 服务器
-	addr := fmt.Sprintf("%s:%d", s.bind, s.port)
-	server := &dns.Server{
-		Addr: addr,
-		Net:  "udp",
+	// 启动所有监听器
+	errChan := make(chan error, len(listeners))
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			if err := l.serve(); err != nil {
+				errChan <- err
+			}
+		}(l)
 	}
 
-	s.logger.Info("DNS 服务器启动: %s", addr)
+	// 等待上下文取消或任一监听器出错
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errChan:
+		s.logger.Error("DNS 服务器错误: %v", serveErr)
+	}
 
-	// 在 goroutine 中启动服务器
-	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			s.logger.Error("DNS 服务器错误: %v", err)
+	// 优雅关闭所有监听器
+	s.logger.Info("正在关闭 DNS 服务器...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, l := range listeners {
+		if err := l.shutdown(shutdownCtx); err != nil {
+			s.logger.Warn("关闭监听器失败: %v", err)
 		}
-	}()
+	}
+	wg.Wait()
 
-	// 等待上下文取消
-	<-ctx.Done()
+	return serveErr
+}
 
-	// 优雅关闭
-	s.logger.Info("正在关闭 DNS 服务器...")
-	return server.Shutdown()
+// listen 按配置创建所有监听器
+func (s *Server) listen(ctx context.Context) ([]listener, error) {
+	var listeners []listener
+	// 已创建的监听器都还没有启动，只需关闭以释放端口
+	fail := func(err error) ([]listener, error) {
+		for _, l := range listeners {
+			l.close()
+		}
+		return nil, err
+	}
+
+	// 明文 DNS（UDP/TCP），端口全部绑定成功后才输出启动日志
+	addr := net.JoinHostPort(s.bind, strconv.Itoa(s.port))
+	if s.protocol == "udp" || s.protocol == "both" {
+		udp, err := newUDPListener(addr, dns.HandlerFunc(s.handleQuery))
+		if err != nil {
+			return fail(fmt.Errorf("监听 UDP 失败: %w", err))
+		}
+		listeners = append(listeners, udp)
+	}
+	if s.protocol == "tcp" || s.protocol == "both" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fail(fmt.Errorf("监听 TCP 失败: %w", err))
+		}
+		listeners = append(listeners, newTCPServer(ln, "tcp", s.idleTimeout, s.handleQuery, s.logger))
+	}
+	s.logger.Info("DNS 服务器启动: %s (%s)", addr, s.protocol)
+
+	// 加密监听器共用同一份证书
+	var certs *certReloader
+	if s.dot.Enable || s.doq.Enable || (s.doh.Enable && !s.doh.Insecure) {
+		var err error
+		certs, err = newCertReloader(s.tls.CertFile, s.tls.KeyFile, s.logger)
+		if err != nil {
+			return fail(err)
+		}
+		go certs.watch(ctx)
+	}
+
+	// DNS-over-TLS (RFC 7858)
+	if s.dot.Enable {
+		dotAddr := net.JoinHostPort(s.bind, strconv.Itoa(s.dot.Port))
+		ln, err := net.Listen("tcp", dotAddr)
+		if err != nil {
+			return fail(fmt.Errorf("监听 DoT 失败: %w", err))
+		}
+		tlsLn := tls.NewListener(ln, certs.tlsConfig("dot"))
+		listeners = append(listeners, newTCPServer(tlsLn, "tls", s.idleTimeout, s.handleQuery, s.logger))
+		s.logger.Info("DoT 服务器启动: %s", dotAddr)
+	}
+
+	// DNS-over-HTTPS (RFC 8484)
+	if s.doh.Enable {
+		dohAddr := net.JoinHostPort(s.bind, strconv.Itoa(s.doh.Port))
+		ln, err := net.Listen("tcp", dohAddr)
+		if err != nil {
+			return fail(fmt.Errorf("监听 DoH 失败: %w", err))
+		}
+		var tlsConfig *tls.Config
+		if !s.doh.Insecure {
+			tlsConfig = certs.tlsConfig("h2", "http/1.1")
+		}
+		listeners = append(listeners, newDoHServer(ln, s.doh.Path, tlsConfig, s.doh.TrustedProxies, s.handleQuery, s.logger))
+		s.logger.Info("DoH 服务器启动: %s%s", dohAddr, s.doh.Path)
+	}
+
+	// DNS-over-QUIC (RFC 9250)
+	if s.doq.Enable {
+		doqAddr := net.JoinHostPort(s.bind, strconv.Itoa(s.doq.Port))
+		doq, err := newDoQServer(doqAddr, certs.tlsConfig("doq"), s.idleTimeout, s.doq.Allow0RTT, s.handleQuery, s.logger)
+		if err != nil {
+			return fail(fmt.Errorf("监听 DoQ 失败: %w", err))
+		}
+		listeners = append(listeners, doq)
+		s.logger.Info("DoQ 服务器启动: %s", doqAddr)
+	}
+
+	return listeners, nil
 }
 
 // handleQuery 处理 DNS 查询
@@ -64,19 +217,20 @@ func (s *Server) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
-	q := r.Question[0]
-	domain := strings.TrimSuffix(q.Name, ".")
-	clientIP := w.RemoteAddr().String()
+	req := router.NewRequest(r, w.RemoteAddr(), transportOf(w))
+	domain := req.Domain
+	clientIP := req.ClientIPString()
 
-	// 生成 trace_id 并创建 context
+	// 生成 trace_id 并创建 context（上游全部卡住时也不会无限占用 TCP 流水线和 DoQ 流）
 	traceID := middleware.NewTraceID()
-	ctx := middleware.WithTraceID(context.Background(), traceID)
+	ctx, cancel := context.WithTimeout(middleware.WithTraceID(context.Background(), traceID), queryTimeout)
+	defer cancel()
 
 	// DEBUG: 记录收到查询请求
-	s.logger.LogQueryStart(ctx, clientIP, domain, q.Qtype)
+	s.logger.LogQueryStart(ctx, clientIP, domain, req.Qtype)
 
 	// 直接调用 router
-	resp, err := s.router.Route(ctx, domain, q.Qtype)
+	resp, err := s.router.Route(ctx, req)
 
 	if err != nil {
 		// ERROR: 记录查询失败
@@ -103,10 +257,18 @@ func (s *Server) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// transportOf 返回查询到达的传输协议（udp, tcp, tls, https, quic）
+func transportOf(w dns.ResponseWriter) string {
+	if t, ok := w.(interface{ transport() string }); ok {
+		return t.transport()
+	}
+	return "udp" // miekg/dns 的 UDP 监听器
+}
+
 // ensureUDPSize 确保 UDP 响应不超过大小限制
 func (s *Server) ensureUDPSize(resp *dns.Msg, req *dns.Msg, w dns.ResponseWriter) *dns.Msg {
 	// 只处理 UDP 连接
-	if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+	if transportOf(w) != "udp" {
 		return resp
 	}
 
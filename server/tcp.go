@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"violet-dns/middleware"
+)
+
+const (
+	maxPipelinedQueries = 32              // 单个连接上同时处理的最大查询数
+	tcpWriteTimeout     = 5 * time.Second // 写入响应超时
+)
+
+// tcpServer 基于流的 DNS 服务（RFC 7766）
+// 同一连接上的多个查询并发处理，响应按完成顺序写回（依赖 message ID 区分）
+type tcpServer struct {
+	ln          net.Listener
+	proto       string // tcp 或 tls
+	idleTimeout time.Duration
+	handler     dns.HandlerFunc
+	logger      *middleware.Logger
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+	connsWg sync.WaitGroup
+}
+
+// newTCPServer 创建 TCP 服务
+func newTCPServer(ln net.Listener, proto string, idleTimeout time.Duration, handler dns.HandlerFunc, logger *middleware.Logger) *tcpServer {
+	return &tcpServer{
+		ln:          ln,
+		proto:       proto,
+		idleTimeout: idleTimeout,
+		handler:     handler,
+		logger:      logger,
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// serve 接受连接，直到监听器关闭
+func (s *tcpServer) serve() error {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// 临时错误，稍后重试
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return fmt.Errorf("TCP accept 失败: %w", err)
+		}
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			return nil
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// trackConn 记录活跃连接，正在关闭时返回 false
+func (s *tcpServer) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.connsWg.Add(1)
+	return true
+}
+
+// untrackConn 移除连接记录
+func (s *tcpServer) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.connsWg.Done()
+}
+
+// isClosing 是否正在关闭
+func (s *tcpServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// serveConn 处理单个连接上的所有查询
+func (s *tcpServer) serveConn(conn net.Conn) {
+	defer s.untrackConn(conn)
+
+	w := &tcpResponseWriter{conn: conn, proto: s.proto}
+	var inflight sync.WaitGroup
+	sem := make(chan struct{}, maxPipelinedQueries)
+
+	for {
+		// 每次读取前刷新空闲超时
+		conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+
+		req, err := readTCPMsg(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.isClosing() {
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					s.logger.Debug("读取 TCP 查询失败: client=%s error=%v", conn.RemoteAddr(), err)
+				}
+			}
+			break
+		}
+
+		// 并发处理流水线查询
+		sem <- struct{}{}
+		inflight.Add(1)
+		go func(req *dns.Msg) {
+			defer func() {
+				<-sem
+				inflight.Done()
+			}()
+			s.handler(w, req)
+		}(req)
+	}
+
+	// 等待已接收的查询全部响应后再关闭连接
+	inflight.Wait()
+	conn.Close()
+}
+
+// shutdown 停止接受新连接，并等待现有连接上的查询处理完成
+func (s *tcpServer) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+
+	// 让空闲连接的读取立即返回
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// 超时，强制关闭剩余连接
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+
+	return err
+}
+
+// close 关闭未启动的监听器
+func (s *tcpServer) close() error {
+	return s.ln.Close()
+}
+
+// readTCPMsg 读取一条带 2 字节长度前缀的 DNS 消息
+func readTCPMsg(r io.Reader) (*dns.Msg, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint16(lenBuf[:])
+	if length < 12 {
+		return nil, fmt.Errorf("消息长度过短: %d", length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("解析 DNS 消息失败: %w", err)
+	}
+	return m, nil
+}
+
+// tcpResponseWriter 流式连接的 dns.ResponseWriter 实现（写入互斥，支持并发响应）
+type tcpResponseWriter struct {
+	conn  net.Conn
+	proto string
+	mu    sync.Mutex
+}
+
+// transport 返回传输协议
+func (w *tcpResponseWriter) transport() string {
+	return w.proto
+}
+
+// LocalAddr 实现 dns.ResponseWriter 接口
+func (w *tcpResponseWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+// RemoteAddr 实现 dns.ResponseWriter 接口
+func (w *tcpResponseWriter) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+// WriteMsg 实现 dns.ResponseWriter 接口
+func (w *tcpResponseWriter) WriteMsg(m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return fmt.Errorf("打包 DNS 响应失败: %w", err)
+	}
+	_, err = w.Write(packed)
+	return err
+}
+
+// Write 实现 dns.ResponseWriter 接口（自动添加长度前缀）
+func (w *tcpResponseWriter) Write(b []byte) (int, error) {
+	if len(b) > dns.MaxMsgSize {
+		return 0, fmt.Errorf("响应过大: %d 字节", len(b))
+	}
+
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	if _, err := w.conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 实现 dns.ResponseWriter 接口
+func (w *tcpResponseWriter) Close() error {
+	return w.conn.Close()
+}
+
+// TsigStatus 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *tcpResponseWriter) TsigStatus() error {
+	return nil
+}
+
+// TsigTimersOnly 实现 dns.ResponseWriter 接口（不支持 TSIG）
+func (w *tcpResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack 实现 dns.ResponseWriter 接口
+func (w *tcpResponseWriter) Hijack() {}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"violet-dns/config"
+	"violet-dns/middleware"
+	"violet-dns/router"
+)
+
+// stubRouter 测试路由器：为每个查询返回 192.0.2.1，域名以 slow. 开头时等待 release 关闭后才返回
+type stubRouter struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	reqs      []*router.Request
+	deadlines []bool // 每个查询的 context 是否带有截止时间
+}
+
+// newStubRouter 创建测试路由器
+func newStubRouter() *stubRouter {
+	return &stubRouter{release: make(chan struct{})}
+}
+
+// Route 实现 router.QueryRouter 接口
+func (r *stubRouter) Route(ctx context.Context, req *router.Request) (*dns.Msg, error) {
+	_, hasDeadline := ctx.Deadline()
+	r.mu.Lock()
+	r.reqs = append(r.reqs, req)
+	r.deadlines = append(r.deadlines, hasDeadline)
+	r.mu.Unlock()
+
+	if strings.HasPrefix(req.Domain, "slow.") {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req.Msg)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(req.Domain), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	return resp, nil
+}
+
+// AddPolicy 实现 router.QueryRouter 接口
+func (r *stubRouter) AddPolicy(*router.Policy) {}
+
+// lastRequest 返回最近一次收到的请求
+func (r *stubRouter) lastRequest() *router.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.reqs) == 0 {
+		return nil
+	}
+	return r.reqs[len(r.reqs)-1]
+}
+
+// newTestServer 创建使用 stubRouter 的服务器
+func newTestServer(cfg config.ServerConfig) (*Server, *stubRouter) {
+	r := newStubRouter()
+	logger := middleware.NewLogger(&middleware.LogConfig{Level: "error"})
+	return NewServer(cfg, r, logger), r
+}
+
+// startTCPServer 在回环地址上启动 TCP 服务，测试结束时关闭
+func startTCPServer(t *testing.T, s *Server, idleTimeout time.Duration) *tcpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTCPServer(ln, "tcp", idleTimeout, s.handleQuery, s.logger)
+	go srv.serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.shutdown(ctx)
+	})
+	return srv
+}
+
+// packTCPQuery 打包一条带 2 字节长度前缀的 A 查询
+func packTCPQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	packed := packQuery(t, id, name)
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)
+}
+
+// readTCPReply 读取一条响应，并检查长度前缀与消息长度一致
+func readTCPReply(t *testing.T, conn net.Conn) *dns.Msg {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+		t.Fatalf("读取长度前缀失败: %v", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	return m
+}
+
+func TestTCPPipelinedOutOfOrder(t *testing.T) {
+	s, r := newTestServer(config.ServerConfig{})
+	srv := startTCPServer(t, s, time.Second)
+
+	conn, err := net.Dial("tcp", srv.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 一次写入两条查询，第一条在上游阻塞
+	batch := append(packTCPQuery(t, 1, "slow.example.com"), packTCPQuery(t, 2, "fast.example.com")...)
+	if _, err := conn.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// 后到的查询先完成，响应按完成顺序写回（RFC 7766 6.2.1.1）
+	first := readTCPReply(t, conn)
+	if first.Id != 2 || first.Question[0].Name != "fast.example.com." {
+		t.Fatalf("第一条响应 id=%d %s, 期望 fast.example.com 的响应", first.Id, first.Question[0].Name)
+	}
+
+	close(r.release)
+	second := readTCPReply(t, conn)
+	if second.Id != 1 || len(second.Answer) != 1 {
+		t.Fatalf("第二条响应 id=%d answers=%d, 期望 slow.example.com 的响应", second.Id, len(second.Answer))
+	}
+
+	// 每个查询都带有处理超时
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, ok := range r.deadlines {
+		if !ok {
+			t.Errorf("第 %d 个查询的 context 没有截止时间", i+1)
+		}
+	}
+}
+
+func TestTCPFraming(t *testing.T) {
+	s, _ := newTestServer(config.ServerConfig{})
+	srv := startTCPServer(t, s, time.Second)
+
+	t.Run("分段写入", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srv.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// 长度前缀和消息体分多次到达
+		query := packTCPQuery(t, 7, "split.example.com")
+		for _, chunk := range [][]byte{query[:1], query[1:2], query[2:10], query[10:]} {
+			if _, err := conn.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if resp := readTCPReply(t, conn); resp.Id != 7 || len(resp.Answer) != 1 {
+			t.Fatalf("响应 id=%d answers=%d, 期望 id=7 且有 1 条应答", resp.Id, len(resp.Answer))
+		}
+	})
+
+	t.Run("长度过短时关闭连接", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srv.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte{0, 5})
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Fatalf("读取结果 %v, 期望连接被关闭", err)
+		}
+	})
+
+	// readTCPMsg 对截断和损坏的输入返回错误
+	valid := packTCPQuery(t, 1, "example.com")
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"空输入", nil, io.EOF},
+		{"只有一个字节的长度", valid[:1], io.ErrUnexpectedEOF},
+		{"消息体截断", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"长度过短", []byte{0, 3, 0, 0, 0}, nil},
+		{"消息体不足", append([]byte{0, 12}, make([]byte, 11)...), io.ErrUnexpectedEOF},
+		{"无法解析", append(append([]byte{0, 13}, valid[2:14]...), 0xff), nil},
+	}
+	for _, tt := range tests {
+		m, err := readTCPMsg(strings.NewReader(string(tt.input)))
+		if err == nil {
+			t.Errorf("%s: 期望返回错误, 得到 %v", tt.name, m)
+			continue
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: 错误 %v, 期望 %v", tt.name, err, tt.err)
+		}
+	}
+
+	if m, err := readTCPMsg(strings.NewReader(string(valid))); err != nil || m.Question[0].Name != "example.com." {
+		t.Errorf("readTCPMsg(完整消息) = %v, %v", m, err)
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	s, _ := newTestServer(config.ServerConfig{})
+	srv := startTCPServer(t, s, 100*time.Millisecond)
+
+	conn, err := net.Dial("tcp", srv.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 空闲超时在每次查询后刷新
+	time.Sleep(60 * time.Millisecond)
+	conn.Write(packTCPQuery(t, 1, "example.com"))
+	readTCPReply(t, conn)
+	time.Sleep(60 * time.Millisecond)
+	conn.Write(packTCPQuery(t, 2, "example.com"))
+	readTCPReply(t, conn)
+
+	// 空闲超过 tcp_idle_timeout 后服务端关闭连接
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("读取结果 %v, 期望连接因空闲被关闭", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("连接在 %v 后才关闭, 期望约 100ms", elapsed)
+	}
+}
+
+func TestServerProtocol(t *testing.T) {
+	tests := []struct {
+		protocol string
+		want     []string
+	}{
+		{"", []string{"udp"}},
+		{"udp", []string{"udp"}},
+		{"tcp", []string{"tcp"}},
+		{"both", []string{"udp", "tcp"}},
+	}
+
+	for _, tt := range tests {
+		s, _ := newTestServer(config.ServerConfig{Bind: "127.0.0.1", Protocol: tt.protocol})
+		listeners, err := s.listen(context.Background())
+		if err != nil {
+			t.Fatalf("protocol %q: %v", tt.protocol, err)
+		}
+
+		var got []string
+		for _, l := range listeners {
+			switch l := l.(type) {
+			case *udpListener:
+				got = append(got, "udp")
+			case *tcpServer:
+				got = append(got, l.proto)
+			}
+			l.close()
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("protocol %q 监听 %v, 期望 %v", tt.protocol, got, tt.want)
+		}
+	}
+}
+
+func TestServerListenFailureReleasesPorts(t *testing.T) {
+	// 占用 TCP 端口，UDP 绑定成功后 TCP 绑定失败
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	s, _ := newTestServer(config.ServerConfig{Bind: "127.0.0.1", Port: port, Protocol: "both"})
+	if _, err := s.listen(context.Background()); err == nil {
+		t.Fatal("TCP 端口被占用时 listen 应返回错误")
+	}
+
+	// 已绑定的 UDP 端口必须释放
+	pc, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("UDP 端口未释放: %v", err)
+	}
+	pc.Close()
+}
+
+func TestServerShutdownBeforeStarted(t *testing.T) {
+	// 监听器还没有开始处理查询时关闭，不能阻塞等待
+	udp, err := newUDPListener("127.0.0.1:0", dns.HandlerFunc(func(dns.ResponseWriter, *dns.Msg) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := udp.server.PacketConn.LocalAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := udp.shutdown(ctx); err != nil {
+		t.Fatalf("关闭未启动的 UDP 监听器失败: %v", err)
+	}
+	if pc, err := net.ListenPacket("udp", addr); err != nil {
+		t.Fatalf("UDP 端口未释放: %v", err)
+	} else {
+		pc.Close()
+	}
+
+	// 上下文已取消时 Start 立即关闭全部监听器并返回
+	s, _ := newTestServer(config.ServerConfig{Bind: "127.0.0.1", Protocol: "both"})
+	startCtx, stop := context.WithCancel(context.Background())
+	stop()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start(startCtx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start 返回错误: %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("Start 没有在关闭后返回")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"violet-dns/middleware"
+)
+
+const certCheckInterval = 10 * time.Second // 证书文件变更检查间隔
+
+// certReloader 证书加载器，检测到证书/私钥文件变更后自动重新加载
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *middleware.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader 创建证书加载器并立即加载一次证书
+func newCertReloader(certFile, keyFile string, logger *middleware.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload 重新加载证书
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("读取证书文件失败: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("读取私钥文件失败: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	r.mu.Unlock()
+
+	return nil
+}
+
+// changed 检查证书或私钥文件是否有变更
+func (r *certReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// watch 定期检查文件变更，直到上下文取消
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+// check 文件有变更时重新加载证书，返回是否已切换到新证书
+func (r *certReloader) check() bool {
+	if !r.changed() {
+		return false
+	}
+	// 加载失败时继续使用旧证书（例如证书和私钥只更新了一半）
+	if err := r.reload(); err != nil {
+		r.logger.Warn("重新加载证书失败，继续使用旧证书: %v", err)
+		return false
+	}
+	r.logger.Info("证书已重新加载: %s", r.certFile)
+	return true
+}
+
+// getCertificate 实现 tls.Config.GetCertificate
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// tlsConfig 生成服务端 TLS 配置
+func (r *certReloader) tlsConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     nextProtos,
+	}
+}
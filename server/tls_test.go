@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"violet-dns/config"
+)
+
+// testCertPair 测试证书文件
+type testCertPair struct {
+	certFile string
+	keyFile  string
+}
+
+// writeTestCert 生成 CommonName 为 cn 的自签名证书并写入 dir（覆盖已有文件，修改时间设为 mod）
+func writeTestCert(t testing.TB, dir, cn string, mod time.Time) testCertPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := testCertPair{
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+	writePEM(t, pair.certFile, "CERTIFICATE", der, mod)
+	writePEM(t, pair.keyFile, "EC PRIVATE KEY", keyDER, mod)
+	return pair
+}
+
+// writePEM 写入 PEM 文件并设置修改时间
+func writePEM(t testing.TB, path, blockType string, der []byte, mod time.Time) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedLeaf 通过 DoT 完成握手和一次查询，返回服务端提供的证书 CommonName
+func servedLeaf(t *testing.T, addr string) string {
+	t.Helper()
+	client := &dns.Client{
+		Net:       "tcp-tls",
+		Timeout:   2 * time.Second,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"dot"}},
+	}
+	conn, err := client.Dial(addr)
+	if err != nil {
+		t.Fatalf("DoT 握手失败: %v", err)
+	}
+	defer conn.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	resp, _, err := client.ExchangeWithConn(m, conn)
+	if err != nil {
+		t.Fatalf("DoT 查询失败: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("DoT 响应应答数 = %d, 期望 1", len(resp.Answer))
+	}
+
+	state := conn.Conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != "dot" {
+		t.Errorf("ALPN = %q, 期望 dot", state.NegotiatedProtocol)
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	pair := writeTestCert(t, dir, "first", start)
+
+	s, _ := newTestServer(config.ServerConfig{})
+	certs, err := newCertReloader(pair.certFile, pair.keyFile, s.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// DoT 监听器使用证书加载器的 TLS 配置
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTCPServer(tls.NewListener(ln, certs.tlsConfig("dot")), "tls", time.Second, s.handleQuery, s.logger)
+	go srv.serve()
+	t.Cleanup(func() { srv.close() })
+	addr := ln.Addr().String()
+
+	if cn := servedLeaf(t, addr); cn != "first" {
+		t.Fatalf("证书 = %s, 期望 first", cn)
+	}
+	if certs.check() {
+		t.Fatal("文件未变更时不应重新加载")
+	}
+
+	// 只更新证书时私钥不匹配，继续使用旧证书
+	keyData, err := os.ReadFile(pair.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestCert(t, dir, "half", start.Add(time.Second))
+	if err := os.WriteFile(pair.keyFile, keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if certs.check() {
+		t.Fatal("证书和私钥不匹配时不应切换证书")
+	}
+	if cn := servedLeaf(t, addr); cn != "first" {
+		t.Fatalf("证书 = %s, 期望继续使用 first", cn)
+	}
+
+	// 证书和私钥都轮换后，新连接使用新证书
+	writeTestCert(t, dir, "second", start.Add(2*time.Second))
+	if !certs.check() {
+		t.Fatal("证书轮换后应重新加载")
+	}
+	if cn := servedLeaf(t, addr); cn != "second" {
+		t.Fatalf("证书 = %s, 期望 second", cn)
+	}
+}
+
+func TestNewCertReloaderMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := newTestServer(config.ServerConfig{})
+	if _, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), s.logger); err == nil {
+		t.Fatal("证书文件不存在时应返回错误")
+	}
+}
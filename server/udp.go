@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// udpListener UDP 监听器（基于 miekg/dns）
+type udpListener struct {
+	server  *dns.Server
+	started chan struct{} // dns.Server 开始处理查询后关闭
+}
+
+// newUDPListener 绑定 UDP 端口并创建监听器（绑定失败时立即返回错误）
+func newUDPListener(addr string, handler dns.Handler) (*udpListener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &udpListener{started: make(chan struct{})}
+	l.server = &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(l.started) },
+	}
+	return l, nil
+}
+
+// serve 启动 UDP 服务
+func (l *udpListener) serve() error {
+	if err := l.server.ActivateAndServe(); err != nil {
+		return fmt.Errorf("UDP 服务错误: %w", err)
+	}
+	return nil
+}
+
+// shutdown 关闭 UDP 服务（尚未开始处理查询时直接关闭套接字，serve 随即返回）
+func (l *udpListener) shutdown(ctx context.Context) error {
+	select {
+	case <-l.started:
+		return l.server.ShutdownContext(ctx)
+	default:
+		return l.close()
+	}
+}
+
+// close 关闭未启动的监听器
+func (l *udpListener) close() error {
+	return l.server.PacketConn.Close()
+}
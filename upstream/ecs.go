@@ -0,0 +1,209 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"violet-dns/config"
+)
+
+// ECS 特殊取值（用于策略 ecs 选项或组 ecs_ip）
+const (
+	ECSNone   = "none"   // 显式禁用 ECS
+	ECSClient = "client" // 使用客户端地址（或客户端携带的 ECS）
+)
+
+// 未配置前缀长度时的默认值
+const (
+	defaultECSIPv4Prefix = 24
+	defaultECSIPv6Prefix = 56
+)
+
+// ecsContextKey 查询 ECS 的 context key
+type ecsContextKey struct{}
+
+// clientContextKey 客户端信息的 context key
+type clientContextKey struct{}
+
+// clientInfo 客户端地址及其携带的 ECS（用于 client 模式）
+type clientInfo struct {
+	ip     net.IP
+	subnet *dns.EDNS0_SUBNET
+}
+
+// WithClient 在 context 中设置客户端地址及其携带的 ECS 选项
+func WithClient(ctx context.Context, ip net.IP, subnet *dns.EDNS0_SUBNET) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, clientInfo{ip: ip, subnet: subnet})
+}
+
+// WithECS 在 context 中设置本次查询的 ECS（query_policy 的 ecs 选项，优先于组配置）
+func WithECS(ctx context.Context, ecs string) context.Context {
+	if ecs == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ecsContextKey{}, ecs)
+}
+
+// ecsFromContext 获取 context 中的 ECS 设置
+func ecsFromContext(ctx context.Context) string {
+	if ecs, ok := ctx.Value(ecsContextKey{}).(string); ok {
+		return ecs
+	}
+	return ""
+}
+
+// ecsSubnet 生成本次查询使用的 ECS 选项，返回 nil 表示不添加 ECS
+func (g *Group) ecsSubnet(ctx context.Context, qtype uint16) (*dns.EDNS0_SUBNET, error) {
+	ecs := g.selectECS(ctx, qtype)
+	if ecs == ECSClient {
+		ecs = clientECSAddress(ctx, g.ecsDefaults)
+	}
+	if ecs == "" {
+		return nil, nil
+	}
+	return newECSOption(ecs, g.ecsDefaults)
+}
+
+// selectECS 确定本次查询使用的 ECS 地址
+// 优先级: 策略 ecs 选项 > 组 ecs_ip > 全局配置（client 模式或默认值，AAAA 查询优先使用 IPv6 默认值）
+// 返回空字符串表示不添加 ECS，返回 ECSClient 表示使用客户端地址
+func (g *Group) selectECS(ctx context.Context, qtype uint16) string {
+	if ecs := ecsFromContext(ctx); ecs != "" {
+		if ecs == ECSNone {
+			return ""
+		}
+		return ecs
+	}
+
+	if g.ecsIP != "" {
+		if g.ecsIP == ECSNone {
+			return ""
+		}
+		return g.ecsIP
+	}
+
+	if !g.ecsDefaults.Enable {
+		return ""
+	}
+	if g.ecsDefaults.Mode == ECSClient {
+		return ECSClient
+	}
+	if qtype == dns.TypeAAAA && g.ecsDefaults.DefaultIPv6 != "" {
+		return g.ecsDefaults.DefaultIPv6
+	}
+	if g.ecsDefaults.DefaultIPv4 != "" {
+		return g.ecsDefaults.DefaultIPv4
+	}
+	return g.ecsDefaults.DefaultIPv6
+}
+
+// newECSOption 根据 IP 或 CIDR 创建 EDNS0_SUBNET 选项，地址按前缀长度截断
+// 前缀长度取 CIDR 掩码与全局 ipv4_prefix / ipv6_prefix 中较短者
+func newECSOption(ecs string, defaults config.ECSConfig) (*dns.EDNS0_SUBNET, error) {
+	ip, ipNet, err := net.ParseCIDR(ecs)
+	if err != nil {
+		// 不是 CIDR 格式，尝试解析为普通 IP
+		ip = net.ParseIP(ecs)
+		if ip == nil {
+			return nil, fmt.Errorf("无效的 ECS 地址: %s", ecs)
+		}
+	}
+
+	subnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+
+	var bits, prefix int
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		subnet.Family = 1 // IPv4
+		bits, prefix = 32, defaults.IPv4Prefix
+		if prefix <= 0 {
+			prefix = defaultECSIPv4Prefix
+		}
+	} else {
+		subnet.Family = 2 // IPv6
+		bits, prefix = 128, defaults.IPv6Prefix
+		if prefix <= 0 {
+			prefix = defaultECSIPv6Prefix
+		}
+	}
+
+	if ipNet != nil {
+		if ones, _ := ipNet.Mask.Size(); ones < prefix {
+			prefix = ones
+		}
+	}
+	if prefix > bits {
+		prefix = bits
+	}
+
+	subnet.SourceNetmask = uint8(prefix)
+	subnet.SourceScope = 0
+	subnet.Address = ip.Mask(net.CIDRMask(prefix, bits))
+
+	return subnet, nil
+}
+
+// clientECSAddress 根据客户端信息确定 ECS 地址
+// 客户端携带 ECS 时使用其子网（前缀为 0 表示客户端拒绝 ECS），否则使用客户端 IP；
+// 内网地址映射为 private_fallback_ipv4/ipv6（未配置时使用 default_ipv4/ipv6）
+func clientECSAddress(ctx context.Context, defaults config.ECSConfig) string {
+	info, ok := ctx.Value(clientContextKey{}).(clientInfo)
+	if !ok {
+		return ""
+	}
+
+	ip := info.ip
+	ones := -1
+	if info.subnet != nil {
+		if info.subnet.SourceNetmask == 0 {
+			return ""
+		}
+		ip = info.subnet.Address
+		ones = int(info.subnet.SourceNetmask)
+	}
+	if ip == nil {
+		return ""
+	}
+
+	if !isPublicIP(ip) {
+		return privateFallback(ip.To4() != nil, defaults)
+	}
+	if ones >= 0 {
+		return fmt.Sprintf("%s/%d", ip, ones)
+	}
+	return ip.String()
+}
+
+// privateFallback 选择内网客户端使用的公网 ECS 地址（优先同一地址族）
+func privateFallback(ipv4 bool, defaults config.ECSConfig) string {
+	candidates := []string{
+		defaults.PrivateFallbackIPv6, defaults.PrivateFallbackIPv4,
+		defaults.DefaultIPv6, defaults.DefaultIPv4,
+	}
+	if ipv4 {
+		candidates = []string{
+			defaults.PrivateFallbackIPv4, defaults.PrivateFallbackIPv6,
+			defaults.DefaultIPv4, defaults.DefaultIPv6,
+		}
+	}
+	for _, c := range candidates {
+		if c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// cgnatNet 运营商级 NAT 地址段（RFC 6598）
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP 判断是否为可用于 ECS 的公网地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || cgnatNet.Contains(ip) {
+		return false
+	}
+	return true
+}
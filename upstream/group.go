@@ -1,15 +1,13 @@
 package upstream
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net"
-	"net/http"
 	"strings"
 	"time"
 
+	"violet-dns/config"
 	"violet-dns/middleware"
 	"violet-dns/outbound"
 
@@ -23,138 +21,21 @@ type Group struct {
 	nameservers []string
 	upstreams   []upstream.Upstream // AdGuard 的 upstream 实例
 	outbound    outbound.Outbound
+	resolver    *Resolver // 解析 nameserver 中的域名，nil 时交给代理解析
 	timeout     time.Duration
-	ecsIP       string // 有值则添加 ECS，空则不添加
+	ecsIP       string           // 组 ECS（none 表示禁用），空则使用全局默认值
+	ecsDefaults config.ECSConfig // 全局 ECS 配置（默认地址与前缀长度）
 	logger      *middleware.Logger
 }
 
-// proxyUpstream 通过 outbound 代理进行 DNS 查询的 upstream 实现
-type proxyUpstream struct {
-	address  string            // DNS 服务器地址 (e.g., "8.8.8.8:53" 或 "https://dns.google/dns-query")
-	protocol string            // 协议: "udp", "tcp", "https"
-	outbound outbound.Outbound // 出站代理
-	timeout  time.Duration
-}
-
-// Exchange 实现 upstream.Upstream 接口
-func (u *proxyUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
-	defer cancel()
-
-	// 根据协议类型选择连接方式
-	switch u.protocol {
-	case "https":
-		return u.exchangeHTTPS(ctx, m)
-	case "tcp":
-		return u.exchangeTCP(ctx, m)
-	default:
-		return nil, fmt.Errorf("不支持的协议: %s (仅支持 https 和 tcp)", u.protocol)
-	}
-}
-
-// exchangeHTTPS 通过 DoH (DNS-over-HTTPS) 进行查询
-func (u *proxyUpstream) exchangeHTTPS(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
-	// 打包 DNS 消息
-	packed, err := m.Pack()
-	if err != nil {
-		return nil, fmt.Errorf("打包 DNS 消息失败: %w", err)
-	}
-
-	// 创建自定义 HTTP Transport，使用 outbound 代理
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// 使用 outbound 的 Dial 方法建立连接
-			return u.outbound.Dial(ctx, network, addr)
-		},
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   u.timeout,
-	}
-	defer client.CloseIdleConnections()
-
-	// 发送 POST 请求
-	req, err := http.NewRequestWithContext(ctx, "POST", u.address, bytes.NewReader(packed))
-	if err != nil {
-		return nil, fmt.Errorf("创建 HTTP 请求失败: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/dns-message")
-	req.Header.Set("Accept", "application/dns-message")
-
-	// 发送请求
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("发送 DoH 请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("DoH 服务器返回错误: %d %s", resp.StatusCode, resp.Status)
-	}
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取 DoH 响应失败: %w", err)
-	}
-
-	// 解析 DNS 响应
-	respMsg := new(dns.Msg)
-	if err := respMsg.Unpack(body); err != nil {
-		return nil, fmt.Errorf("解析 DNS 响应失败: %w", err)
-	}
-
-	return respMsg, nil
-}
-
-// exchangeTCP 通过 TCP 进行 DNS 查询
-func (u *proxyUpstream) exchangeTCP(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
-	// 使用 outbound 建立 TCP 连接
-	conn, err := u.outbound.Dial(ctx, "tcp", u.address)
-	if err != nil {
-		return nil, fmt.Errorf("代理连接失败: %w", err)
-	}
-	defer conn.Close()
-
-	// 创建 DNS 连接
-	dnsConn := &dns.Conn{Conn: conn}
-
-	// 发送查询
-	if err := dnsConn.WriteMsg(m); err != nil {
-		return nil, fmt.Errorf("发送 DNS 查询失败: %w", err)
-	}
-
-	// 接收响应
-	resp, err := dnsConn.ReadMsg()
-	if err != nil {
-		return nil, fmt.Errorf("读取 DNS 响应失败: %w", err)
-	}
-
-	return resp, nil
-}
-
-// Address 实现 upstream.Upstream 接口
-func (u *proxyUpstream) Address() string {
-	return u.address
-}
-
-// Close 实现 upstream.Upstream 接口
-func (u *proxyUpstream) Close() error {
-	return nil
-}
-
 // NewGroup 创建新的上游组
-func NewGroup(name string, nameservers []string, ob outbound.Outbound, timeout time.Duration, logger *middleware.Logger) *Group {
+// resolver 用于解析 nameserver 中的域名；代理出站传入 nil 时由代理服务器解析
+func NewGroup(name string, nameservers []string, ob outbound.Outbound, resolver *Resolver, timeout time.Duration, logger *middleware.Logger) *Group {
 	g := &Group{
 		name:        name,
 		nameservers: nameservers,
 		outbound:    ob,
+		resolver:    resolver,
 		timeout:     timeout,
 		logger:      logger,
 		upstreams:   make([]upstream.Upstream, 0, len(nameservers)),
@@ -186,19 +67,16 @@ func (g *Group) createUpstream(nameserver string) (upstream.Upstream, error) {
 		// 对于所有协议（包括加密协议），都使用我们的 proxyUpstream
 		g.logger.Debug("创建代理 upstream: nameserver=%s protocol=%s address=%s", nameserver, protocol, address)
 
-		return &proxyUpstream{
-			address:  address,
-			protocol: protocol,
-			outbound: g.outbound,
-			timeout:  g.timeout,
-		}, nil
+		return newProxyUpstream(address, protocol, g.outbound, g.resolver, g.timeout), nil
 	}
 
 	// 不需要代理，使用 AdGuard upstream
 	// 使用 AdGuard upstream 库创建
 	opts := &upstream.Options{
 		Timeout: g.timeout,
-		// 不设置 Bootstrap，让库使用系统 DNS
+	}
+	if g.resolver != nil {
+		opts.Bootstrap = g.resolver
 	}
 
 	u, err := upstream.AddressToUpstream(nameserver, opts)
@@ -206,6 +84,12 @@ func (g *Group) createUpstream(nameserver string) (upstream.Upstream, error) {
 		return nil, fmt.Errorf("创建 upstream 失败: %w", err)
 	}
 
+	// nameserver 为域名时固定使用解析出的 IP，按记录 TTL 重新解析
+	if host := nameserverHost(nameserver); host != "" && g.resolver != nil {
+		u.Close()
+		return newPinnedUpstream(nameserver, host, opts, g.resolver), nil
+	}
+
 	return u, nil
 }
 
@@ -220,14 +104,14 @@ func (g *Group) needsProxy() bool {
 func (g *Group) parseNameserver(nameserver string) (protocol, address string) {
 	// 支持的格式:
 	// - https://dns.google/dns-query (DoH)
-	// - tls://dns.google (DoT) - 不支持代理
-	// - quic://dns.adguard.com (DoQ) - 不支持代理
+	// - tls://dns.google (DoT, 默认端口 853)
+	// - quic://dns.adguard.com (DoQ, 默认端口 853，代理出站需支持 UDP)
 	// - tcp://8.8.8.8:53 (TCP)
-	// - udp://8.8.8.8:53 (UDP) - 仅 direct 出站支持
-	// - 8.8.8.8:53 (默认 UDP, 仅 direct 出站支持)
-	// - 8.8.8.8 (默认 UDP, 端口 53, 仅 direct 出站支持)
+	// - udp://8.8.8.8:53 (UDP)
+	// - 8.8.8.8:53 (默认 UDP)
+	// - 8.8.8.8 (默认 UDP, 端口 53)
 	//
-	// 注意: SOCKS5 代理仅支持 HTTPS (DoH) 和 TCP 协议
+	// 注意: SOCKS5 代理通过 UDP ASSOCIATE 转发 UDP 和 DoQ，代理拒绝 UDP 时 UDP 查询改用 TCP
 
 	// 如果包含 ://，提取协议
 	if strings.Contains(nameserver, "://") {
@@ -241,10 +125,13 @@ func (g *Group) parseNameserver(nameserver string) (protocol, address string) {
 			return protocol, address
 		}
 
-		// 对于 TLS/QUIC，暂不支持代理（需要额外实现）
+		// 对于 TLS/QUIC，补全默认端口 853
 		if protocol == "tls" || protocol == "quic" {
-			// 返回原始地址，让 AdGuard upstream 处理
-			return protocol, nameserver
+			address = strings.TrimSuffix(address, "/")
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(strings.Trim(address, "[]"), "853")
+			}
+			return protocol, address
 		}
 
 		// 对于普通 DNS，确保有端口
@@ -255,7 +142,7 @@ func (g *Group) parseNameserver(nameserver string) (protocol, address string) {
 		return protocol, address
 	}
 
-	// 没有协议前缀，默认为 UDP (仅 direct 出站支持)
+	// 没有协议前缀，默认为 UDP
 	address = nameserver
 	if !strings.Contains(address, ":") {
 		// 检查是否是 IP 地址
@@ -276,10 +163,12 @@ func (g *Group) Query(ctx context.Context, domain string, qtype uint16) (*dns.Ms
 	m.SetQuestion(dns.Fqdn(domain), qtype)
 	m.RecursionDesired = true
 
-	// 添加 ECS（仅当配置了 ecs_ip 时）
-	if g.ecsIP != "" {
-		g.addECS(m, g.ecsIP)
-		g.logger.Debug("添加ECS: domain=%s ecs_ip=%s", domain, g.ecsIP)
+	// 添加 ECS（策略 ecs 选项 > 组 ecs_ip > 全局配置）
+	if subnet, err := g.ecsSubnet(ctx, qtype); err != nil {
+		g.logger.Debug("%v", err)
+	} else if subnet != nil {
+		g.addECS(m, subnet)
+		g.logger.Debug("添加ECS: domain=%s ecs=%s/%d", domain, subnet.Address, subnet.SourceNetmask)
 	}
 
 	// 并发查询所有 upstream
@@ -441,44 +330,11 @@ func formatRR(rr dns.RR) string {
 }
 
 // addECS 添加 EDNS Client Subnet
-func (g *Group) addECS(m *dns.Msg, ecsIP string) {
-	// 解析 ECS IP（可能是 CIDR 格式）
-	ip, ipNet, err := net.ParseCIDR(ecsIP)
-	if err != nil {
-		// 不是 CIDR 格式，尝试解析为普通 IP
-		ip = net.ParseIP(ecsIP)
-		if ip == nil {
-			g.logger.Debug("无效的 ECS IP: %s", ecsIP)
-			return
-		}
-		// 使用默认掩码
-		if ip.To4() != nil {
-			_, ipNet, _ = net.ParseCIDR(ecsIP + "/24")
-		} else {
-			_, ipNet, _ = net.ParseCIDR(ecsIP + "/56")
-		}
-	}
-
-	// 创建 EDNS0_SUBNET 选项
+func (g *Group) addECS(m *dns.Msg, subnet *dns.EDNS0_SUBNET) {
 	opt := new(dns.OPT)
 	opt.Hdr.Name = "."
 	opt.Hdr.Rrtype = dns.TypeOPT
 
-	subnet := new(dns.EDNS0_SUBNET)
-	subnet.Code = dns.EDNS0SUBNET
-
-	if ip.To4() != nil {
-		subnet.Family = 1 // IPv4
-		subnet.Address = ip.To4()
-	} else {
-		subnet.Family = 2 // IPv6
-		subnet.Address = ip.To16()
-	}
-
-	ones, _ := ipNet.Mask.Size()
-	subnet.SourceNetmask = uint8(ones)
-	subnet.SourceScope = 0
-
 	opt.Option = append(opt.Option, subnet)
 	m.Extra = append(m.Extra, opt)
 }
@@ -488,6 +344,11 @@ func (g *Group) SetECS(ecsIP string) {
 	g.ecsIP = ecsIP
 }
 
+// SetECSDefaults 设置全局 ECS 配置
+func (g *Group) SetECSDefaults(defaults config.ECSConfig) {
+	g.ecsDefaults = defaults
+}
+
 // Close 关闭所有 upstream 连接
 func (g *Group) Close() error {
 	for _, u := range g.upstreams {
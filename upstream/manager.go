@@ -49,10 +49,31 @@ func (m *Manager) Query(ctx context.Context, groupName, domain string, qtype uin
 	return group.Query(ctx, domain, qtype)
 }
 
+// ClientSubnet 返回查询指定组时按客户端生成的 ECS 子网（如 1.2.3.0/24）
+// 仅当该组在本次查询中使用 client 模式时返回非空值，用于隔离不同子网的 DNS 缓存
+func (m *Manager) ClientSubnet(ctx context.Context, groupName string) string {
+	group, exists := m.GetGroup(groupName)
+	if !exists || group.selectECS(ctx, dns.TypeA) != ECSClient {
+		return ""
+	}
+
+	subnet, err := group.ecsSubnet(ctx, dns.TypeA)
+	if err != nil || subnet == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", subnet.Address, subnet.SourceNetmask)
+}
+
 // LoadFromConfig 从配置加载上游组
 func (m *Manager) LoadFromConfig(cfg *config.Config, outbounds map[string]outbound.Outbound) error {
 	const defaultTimeout = 5 * time.Second // 固定超时时间为 5 秒
 
+	// bootstrap 解析器在所有组间共享，解析结果按 TTL 缓存
+	bootstrap, err := NewResolver(cfg.Bootstrap.Nameservers, defaultTimeout)
+	if err != nil {
+		return fmt.Errorf("创建 bootstrap 解析器失败: %w", err)
+	}
+
 	for name, groupCfg := range cfg.UpstreamGroup {
 		// 获取 outbound
 		ob := outbounds["direct"] // 默认使用 direct
@@ -62,25 +83,42 @@ func (m *Manager) LoadFromConfig(cfg *config.Config, outbounds map[string]outbou
 			}
 		}
 
+		// 组内配置了 resolve_nameservers 时使用独立的解析器，否则使用 bootstrap
+		resolver := bootstrap
+		if len(groupCfg.ResolveNameservers) > 0 {
+			resolver, err = NewResolver(groupCfg.ResolveNameservers, defaultTimeout)
+			if err != nil {
+				return fmt.Errorf("组 %s resolve_nameservers: %w", name, err)
+			}
+		}
+		resolver = resolver.WithStrategy(groupCfg.ResolveStrategy)
+
+		// 代理出站默认由代理服务器解析 nameserver 中的域名；
+		// resolve_mode: local 或配置了 resolve_nameservers/resolve_strategy 时改为本地解析
+		localResolve := groupCfg.ResolveMode == "local" ||
+			(groupCfg.ResolveMode == "" && (len(groupCfg.ResolveNameservers) > 0 || groupCfg.ResolveStrategy != ""))
+		if _, isDirect := ob.(*outbound.DirectOutbound); !isDirect && !localResolve {
+			resolver = nil
+		}
+
 		// 创建组
 		group := NewGroup(
 			name,
 			groupCfg.Nameservers,
 			ob,
+			resolver,
 			defaultTimeout,
 			m.logger,
 		)
 
 		// 设置 ECS
-		// 逻辑:
-		// 1. 如果 group 配置了 ecs_ip，使用 group 的配置
-		// 2. 如果 group 是 proxy_ecs 且未配置 ecs_ip，且全局 ECS 启用，使用全局默认值
-		// 3. 否则不添加 ECS
-		ecsIP := groupCfg.ECSIP
-		if ecsIP == "" && name == "proxy_ecs" && cfg.ECS.Enable {
-			ecsIP = cfg.ECS.DefaultIPv4
-		}
-		group.SetECS(ecsIP)
+		// 查询时的选择逻辑:
+		// 1. 如果 query_policy 配置了 ecs 选项，使用策略的配置
+		// 2. 如果 group 配置了 ecs_ip，使用 group 的配置（none 表示不添加）
+		// 3. 如果全局 ECS 启用，client 模式使用客户端地址，否则使用全局默认值（AAAA 查询优先使用 default_ipv6）
+		// 4. 否则不添加 ECS
+		group.SetECS(groupCfg.ECSIP)
+		group.SetECSDefaults(cfg.ECS)
 
 		m.AddGroup(name, group)
 	}
@@ -0,0 +1,168 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// pinnedUpstream 固定连接到解析出的 IP 的 AdGuard upstream（nameserver 为域名时使用）
+// 记录 TTL 过期后重新解析，IP 变化时重建 upstream；TLS 仍使用 nameserver 中的域名作为 SNI
+type pinnedUpstream struct {
+	nameserver string
+	host       string
+	opts       upstream.Options
+	resolver   *Resolver
+
+	mu         sync.Mutex
+	current    upstream.Upstream
+	addrs      []netip.Addr
+	expireAt   time.Time
+	refreshing bool // 正在后台重新解析
+	closed     bool
+}
+
+// newPinnedUpstream 创建固定 IP 的 upstream（首次查询时解析）
+func newPinnedUpstream(nameserver, host string, opts *upstream.Options, resolver *Resolver) *pinnedUpstream {
+	return &pinnedUpstream{
+		nameserver: nameserver,
+		host:       host,
+		opts:       *opts,
+		resolver:   resolver,
+	}
+}
+
+// Exchange 实现 upstream.Upstream 接口
+func (u *pinnedUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	ups, err := u.upstream()
+	if err != nil {
+		return nil, err
+	}
+	return ups.Exchange(m)
+}
+
+// upstream 返回当前的 upstream
+// 解析结果过期时继续使用当前 upstream，由一个 goroutine 在后台重新解析；只有首次查询需要等待解析
+func (u *pinnedUpstream) upstream() (upstream.Upstream, error) {
+	u.mu.Lock()
+	current := u.current
+	if current != nil && !time.Now().Before(u.expireAt) && !u.refreshing {
+		u.refreshing = true
+		go u.refresh()
+	}
+	u.mu.Unlock()
+
+	if current != nil {
+		return current, nil
+	}
+	return u.refresh()
+}
+
+// refresh 重新解析 nameserver 中的域名（不持有锁），IP 变化时重建 upstream
+func (u *pinnedUpstream) refresh() (upstream.Upstream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), u.opts.Timeout)
+	defer cancel()
+
+	addrs, expireAt, err := u.resolver.resolve(ctx, "ip", u.host)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refreshing = false
+
+	if u.closed {
+		return nil, fmt.Errorf("upstream 已关闭")
+	}
+	if err != nil {
+		if u.current != nil {
+			// 重新解析失败时继续使用原来的 IP，稍后再试
+			u.expireAt = time.Now().Add(resolverMinTTL)
+			return u.current, nil
+		}
+		return nil, err
+	}
+
+	u.expireAt = expireAt
+	if u.current != nil && slices.Equal(addrs, u.addrs) {
+		return u.current, nil
+	}
+
+	opts := u.opts
+	opts.Bootstrap = staticResolver(addrs)
+	ups, err := upstream.AddressToUpstream(u.nameserver, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 旧 upstream 上可能还有进行中的查询，超时后再关闭
+	if old := u.current; old != nil {
+		time.AfterFunc(u.opts.Timeout, func() { old.Close() })
+	}
+
+	u.current, u.addrs = ups, addrs
+	return ups, nil
+}
+
+// Address 实现 upstream.Upstream 接口
+func (u *pinnedUpstream) Address() string {
+	return u.nameserver
+}
+
+// Close 实现 upstream.Upstream 接口
+func (u *pinnedUpstream) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.closed = true
+	if u.current == nil {
+		return nil
+	}
+	err := u.current.Close()
+	u.current = nil
+	return err
+}
+
+// staticResolver 总是返回固定地址的解析器
+type staticResolver []netip.Addr
+
+// LookupNetIP 实现 AdGuard upstream 的 Bootstrap 接口
+func (r staticResolver) LookupNetIP(_ context.Context, network, _ string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, addr := range r {
+		if (network == "ip4" && !addr.Is4()) || (network == "ip6" && !addr.Is6()) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// nameserverHost 返回 nameserver 中需要解析的域名，IP 地址或 DNS Stamp 返回空
+func nameserverHost(nameserver string) string {
+	address := nameserver
+	if proto, rest, ok := strings.Cut(nameserver, "://"); ok {
+		if proto == "sdns" {
+			return ""
+		}
+		address = rest
+		if i := strings.IndexByte(address, '/'); i >= 0 {
+			address = address[:i]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.Trim(address, "[]")
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestNameserverHost(t *testing.T) {
+	tests := []struct {
+		nameserver string
+		host       string
+	}{
+		{"https://dns.google/dns-query", "dns.google"},
+		{"https://dns.google:8443/dns-query", "dns.google"},
+		{"tls://dns.google", "dns.google"},
+		{"tls://dns.google:853", "dns.google"},
+		{"quic://dns.adguard-dns.com", "dns.adguard-dns.com"},
+		{"h3://dns.google/dns-query", "dns.google"},
+		{"dns.google", "dns.google"},
+		{"dns.google:53", "dns.google"},
+		{"8.8.8.8", ""},
+		{"8.8.8.8:53", ""},
+		{"udp://8.8.8.8:53", ""},
+		{"https://1.1.1.1/dns-query", ""},
+		{"[2001:4860:4860::8888]:53", ""},
+		{"tls://[2001:4860:4860::8888]", ""},
+		{"https://[2606:4700:4700::1111]:443/dns-query", ""},
+		{"2001:4860:4860::8888", ""},
+		{"sdns://AgcAAAAAAAAABzEuMC4wLjEAEmRucy5jbG91ZGZsYXJlLmNvbQovZG5zLXF1ZXJ5", ""},
+		{"tls://", ""},
+	}
+
+	for _, tt := range tests {
+		if got := nameserverHost(tt.nameserver); got != tt.host {
+			t.Errorf("nameserverHost(%s) = %q, 期望 %q", tt.nameserver, got, tt.host)
+		}
+	}
+}
+
+func TestStaticResolver(t *testing.T) {
+	v4 := netip.MustParseAddr("8.8.8.8")
+	v6 := netip.MustParseAddr("2001:4860:4860::8888")
+	r := staticResolver{v4, v6}
+
+	tests := []struct {
+		network string
+		want    []netip.Addr
+	}{
+		{"ip", []netip.Addr{v4, v6}},
+		{"ip4", []netip.Addr{v4}},
+		{"ip6", []netip.Addr{v6}},
+	}
+
+	for _, tt := range tests {
+		got, err := r.LookupNetIP(context.Background(), tt.network, "dns.google")
+		if err != nil {
+			t.Fatalf("LookupNetIP(%s): %v", tt.network, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("LookupNetIP(%s) = %v, 期望 %v", tt.network, got, tt.want)
+		}
+	}
+
+	got, err := staticResolver{v4}.LookupNetIP(context.Background(), "ip6", "dns.google")
+	if err != nil || len(got) != 0 {
+		t.Errorf("只有 IPv4 时 LookupNetIP(ip6) = %v, %v, 期望为空", got, err)
+	}
+}
@@ -0,0 +1,311 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"violet-dns/outbound"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/sync/singleflight"
+)
+
+// DoH 连接池参数
+const (
+	dohIdleConnTimeout  = 90 * time.Second // 空闲连接保留时间
+	dohSendPingTimeout  = 30 * time.Second // HTTP/2 连接空闲多久后发送 PING 探测
+	dohPingTimeout      = 10 * time.Second // PING 无响应多久后判定连接失效
+	dohMaxIdleConns     = 4                // 每个上游保留的空闲连接数（HTTP/1.1 回退时生效）
+	dohHandshakeTimeout = 10 * time.Second // TLS 握手超时
+)
+
+// proxyUpstream 通过 outbound 代理进行 DNS 查询的 upstream 实现
+type proxyUpstream struct {
+	address  string            // DNS 服务器地址 (e.g., "8.8.8.8:53"、"dns.google:853" 或 "https://dns.google/dns-query")
+	protocol string            // 协议: "udp", "tcp", "https", "tls", "quic"
+	outbound outbound.Outbound // 出站代理
+	resolver *Resolver         // 本地解析 address 中的域名，nil 时交给代理解析
+	timeout  time.Duration
+
+	client    *http.Client // DoH 长连接客户端（同一上游的查询复用 HTTP/2 连接）
+	tlsConfig *tls.Config  // DoT/DoQ 的 TLS 配置
+
+	mu      sync.Mutex
+	tlsIdle []*idleConn // DoT 空闲连接
+	udpIdle []*idleConn // 空闲的 UDP 中继（SOCKS5 UDP ASSOCIATE）
+	quic    *quic.Conn  // DoQ 连接
+	closed  bool
+
+	quicDial singleflight.Group // 合并并发查询的 DoQ 握手
+
+	udpRefusedAt atomic.Int64 // 代理最近一次拒绝 UDP 的时间（UnixNano），udpRetryInterval 内的 UDP 查询直接改用 TCP
+}
+
+// udpRetryInterval 代理拒绝 UDP 后改用 TCP 的时长，之后重新尝试 UDP（代理配置可能已经变更）
+const udpRetryInterval = 5 * time.Minute
+
+// newProxyUpstream 创建代理 upstream
+func newProxyUpstream(address, protocol string, ob outbound.Outbound, resolver *Resolver, timeout time.Duration) *proxyUpstream {
+	u := &proxyUpstream{
+		address:  address,
+		protocol: protocol,
+		outbound: ob,
+		resolver: resolver,
+		timeout:  timeout,
+	}
+
+	switch protocol {
+	case "https":
+		u.client = u.newHTTPClient()
+	case "tls":
+		u.tlsConfig = newTLSConfig(address)
+	case "quic":
+		u.tlsConfig = newTLSConfig(address, "doq")
+		u.tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	return u
+}
+
+// newHTTPClient 创建 DoH 客户端
+// 连接经 outbound 建立后长期保留：HTTP/2 下所有查询复用同一条连接，
+// 空闲时定期 PING 探测，失效连接由 Transport 自动丢弃并在下次查询时重连
+func (u *proxyUpstream) newHTTPClient() *http.Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// 经 outbound 建立连接（TLS 握手仍使用 URL 中的域名作为 SNI）
+			return u.dial(ctx, addr)
+		},
+		ForceAttemptHTTP2:     true, // 自定义 DialContext 时需要显式启用 HTTP/2
+		MaxIdleConnsPerHost:   dohMaxIdleConns,
+		IdleConnTimeout:       dohIdleConnTimeout,
+		TLSHandshakeTimeout:   dohHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		HTTP2: &http.HTTP2Config{
+			SendPingTimeout: dohSendPingTimeout,
+			PingTimeout:     dohPingTimeout,
+		},
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   u.timeout,
+	}
+}
+
+// Exchange 实现 upstream.Upstream 接口
+func (u *proxyUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+
+	// 根据协议类型选择连接方式
+	switch u.protocol {
+	case "https":
+		return u.exchangeHTTPS(ctx, m)
+	case "tls":
+		return u.exchangeTLS(ctx, m)
+	case "quic":
+		return u.exchangeQUIC(ctx, m)
+	case "tcp":
+		return u.exchangeTCP(ctx, m)
+	case "udp":
+		return u.exchangeUDP(ctx, m)
+	default:
+		return nil, fmt.Errorf("不支持的协议: %s", u.protocol)
+	}
+}
+
+// exchangeHTTPS 通过 DoH (DNS-over-HTTPS) 进行查询
+// 复用的连接可能已被服务器或代理关闭，请求发送失败时丢弃空闲连接并重新建立连接重试一次
+func (u *proxyUpstream) exchangeHTTPS(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// 打包 DNS 消息
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("打包 DNS 消息失败: %w", err)
+	}
+
+	resp, err := u.doHTTPS(ctx, packed)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && ctx.Err() == nil {
+		u.client.CloseIdleConnections()
+		resp, err = u.doHTTPS(ctx, packed)
+	}
+	return resp, err
+}
+
+// doHTTPS 发送一次 DoH 请求
+func (u *proxyUpstream) doHTTPS(ctx context.Context, packed []byte) (*dns.Msg, error) {
+	// 发送 POST 请求
+	req, err := http.NewRequestWithContext(ctx, "POST", u.address, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("创建 HTTP 请求失败: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	// 发送请求
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送 DoH 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH 服务器返回错误: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	// 读取响应
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取 DoH 响应失败: %w", err)
+	}
+
+	// 解析 DNS 响应
+	respMsg := new(dns.Msg)
+	if err := respMsg.Unpack(body); err != nil {
+		return nil, fmt.Errorf("解析 DNS 响应失败: %w", err)
+	}
+
+	return respMsg, nil
+}
+
+// exchangeTCP 通过 TCP 进行 DNS 查询
+func (u *proxyUpstream) exchangeTCP(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// 使用 outbound 建立 TCP 连接
+	conn, err := u.dial(ctx, u.address)
+	if err != nil {
+		return nil, fmt.Errorf("代理连接失败: %w", err)
+	}
+	defer conn.Close()
+
+	return exchangeConn(ctx, &dns.Conn{Conn: conn}, m)
+}
+
+// exchangeUDP 通过 UDP 进行 DNS 查询（经代理的 UDP 中继）
+// 中继与 DoT 连接一样放回空闲池复用（每个中继需要一条 SOCKS5 控制连接和一次 ASSOCIATE 握手），
+// 复用的中继失效时重新建立中继重试一次；查询失败的中继直接关闭，迟到的响应不会被下一条查询读到。
+// 代理拒绝 UDP 时在 udpRetryInterval 内改用 TCP；响应被截断时用 TCP 重新查询
+func (u *proxyUpstream) exchangeUDP(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if u.udpRefusedRecently() {
+		return u.exchangeTCP(ctx, m)
+	}
+
+	if conn := u.takeIdleConn(&u.udpIdle, udpIdleConnTimeout); conn != nil {
+		resp, err := exchangeConn(ctx, conn, m)
+		if err == nil {
+			return u.finishUDP(ctx, conn, m, resp)
+		}
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := u.dialUDP(ctx, u.address)
+	if errors.Is(err, outbound.ErrUDPRefused) {
+		u.udpRefusedAt.Store(time.Now().UnixNano())
+		return u.exchangeTCP(ctx, m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("建立 UDP 中继失败: %w", err)
+	}
+
+	conn := &dns.Conn{Conn: raw, UDPSize: dns.MaxMsgSize}
+	resp, err := exchangeConn(ctx, conn, m)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return u.finishUDP(ctx, conn, m, resp)
+}
+
+// finishUDP 将中继放回空闲池，响应被截断时用 TCP 重新查询
+func (u *proxyUpstream) finishUDP(ctx context.Context, conn *dns.Conn, m, resp *dns.Msg) (*dns.Msg, error) {
+	u.releaseIdleConn(&u.udpIdle, conn)
+	if resp.Truncated {
+		return u.exchangeTCP(ctx, m)
+	}
+	return resp, nil
+}
+
+// udpRefusedRecently 代理是否在 udpRetryInterval 内拒绝过 UDP
+func (u *proxyUpstream) udpRefusedRecently() bool {
+	at := u.udpRefusedAt.Load()
+	return at != 0 && time.Since(time.Unix(0, at)) < udpRetryInterval
+}
+
+// dial 经 outbound 建立 TCP 连接
+func (u *proxyUpstream) dial(ctx context.Context, address string) (net.Conn, error) {
+	return u.dialResolved(ctx, address, func(addr string) (net.Conn, error) {
+		return u.outbound.Dial(ctx, "tcp", addr)
+	})
+}
+
+// dialUDP 经 outbound 建立 UDP 连接
+func (u *proxyUpstream) dialUDP(ctx context.Context, address string) (net.Conn, error) {
+	return u.dialResolved(ctx, address, func(addr string) (net.Conn, error) {
+		return u.outbound.DialUDP(ctx, addr)
+	})
+}
+
+// dialResolved 配置了本地解析时先解析 address 中的域名，依次尝试各个 IP；
+// 否则直接使用原地址，由代理服务器解析
+func (u *proxyUpstream) dialResolved(ctx context.Context, address string, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || u.resolver == nil || net.ParseIP(host) != nil {
+		return dial(address)
+	}
+
+	addrs, err := u.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dial(net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Address 实现 upstream.Upstream 接口
+func (u *proxyUpstream) Address() string {
+	return u.address
+}
+
+// Close 实现 upstream.Upstream 接口，关闭保持的连接
+func (u *proxyUpstream) Close() error {
+	if u.client != nil {
+		u.client.CloseIdleConnections()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	for _, idle := range append(u.tlsIdle, u.udpIdle...) {
+		idle.conn.Close()
+	}
+	u.tlsIdle = nil
+	u.udpIdle = nil
+	if u.quic != nil {
+		u.quic.CloseWithError(0, "")
+		u.quic = nil
+	}
+	return nil
+}
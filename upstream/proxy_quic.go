@@ -0,0 +1,146 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqIdleTimeout DoQ 连接空闲超时（超时后连接关闭，下次查询重新建立）
+const doqIdleTimeout = 30 * time.Second
+
+// exchangeQUIC 通过 DoQ (DNS-over-QUIC, RFC 9250) 进行查询
+// 所有查询复用同一条 QUIC 连接，每条查询一个双向流；连接失效时重新建立连接重试一次
+func (u *proxyUpstream) exchangeQUIC(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	conn, err := u.quicConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := exchangeQUICStream(ctx, conn, m)
+	if err != nil && ctx.Err() == nil {
+		conn.CloseWithError(0, "")
+		if conn, err = u.quicConn(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = exchangeQUICStream(ctx, conn, m)
+	}
+	return resp, err
+}
+
+// quicConn 返回当前可用的 QUIC 连接，没有时经 outbound 的 UDP 中继建立
+// 握手期间不持有 u.mu（Close 和其他查询不被阻塞），并发的查询共享同一次握手
+func (u *proxyUpstream) quicConn(ctx context.Context) (*quic.Conn, error) {
+	if conn, err := u.currentQUIC(); conn != nil || err != nil {
+		return conn, err
+	}
+
+	v, err, _ := u.quicDial.Do("", func() (interface{}, error) {
+		// 共享的握手不受首个调用者取消的影响，超时由 u.timeout 单独控制
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.timeout)
+		defer cancel()
+		return u.dialQUIC(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*quic.Conn), nil
+}
+
+// currentQUIC 返回当前可用的 QUIC 连接（没有时返回 nil），upstream 已关闭时返回错误
+func (u *proxyUpstream) currentQUIC() (*quic.Conn, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return nil, fmt.Errorf("upstream 已关闭")
+	}
+	if u.quic != nil && u.quic.Context().Err() == nil {
+		return u.quic, nil
+	}
+	return nil, nil
+}
+
+// dialQUIC 经 outbound 的 UDP 中继建立 QUIC 连接并设为当前连接
+func (u *proxyUpstream) dialQUIC(ctx context.Context) (*quic.Conn, error) {
+	udp, err := u.dialUDP(ctx, u.address)
+	if err != nil {
+		return nil, fmt.Errorf("建立 UDP 中继失败: %w", err)
+	}
+
+	conn, err := quic.Dial(ctx, udp.(net.PacketConn), udp.RemoteAddr(), u.tlsConfig, &quic.Config{
+		MaxIdleTimeout: doqIdleTimeout,
+	})
+	if err != nil {
+		udp.Close()
+		return nil, fmt.Errorf("QUIC 握手失败: %w", err)
+	}
+
+	// quic.Dial 不负责关闭传入的 PacketConn，连接结束后关闭中继
+	go func() {
+		<-conn.Context().Done()
+		udp.Close()
+	}()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// 握手期间 upstream 被关闭
+	if u.closed {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("upstream 已关闭")
+	}
+	u.quic = conn
+	return conn, nil
+}
+
+// exchangeQUICStream 在新的双向流上完成一次查询
+// RFC 9250 4.2.1: 消息 ID 必须为 0，消息以 2 字节长度为前缀，发送后关闭写方向
+func exchangeQUICStream(ctx context.Context, conn *quic.Conn, m *dns.Msg) (*dns.Msg, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("打开 QUIC 流失败: %w", err)
+	}
+	defer stream.CancelRead(0)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	// 查询在多个上游间共享，复制后再修改 ID
+	req := m.Copy()
+	req.Id = 0
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("打包 DNS 消息失败: %w", err)
+	}
+
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(packed)), uint16(len(packed)))
+	if _, err := stream.Write(append(buf, packed...)); err != nil {
+		return nil, fmt.Errorf("发送 DNS 查询失败: %w", err)
+	}
+	stream.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, fmt.Errorf("读取 DNS 响应失败: %w", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, fmt.Errorf("读取 DNS 响应失败: %w", err)
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("解析 DNS 响应失败: %w", err)
+	}
+	resp.Id = m.Id
+
+	return resp, nil
+}
@@ -0,0 +1,165 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqServer 回环地址上的 DoQ 测试服务，TXT 记录为 "quic"
+type doqServer struct {
+	ln    *quic.Listener
+	pool  *x509.CertPool // 信任服务证书的 CertPool
+	conns atomic.Int32   // 建立的 QUIC 连接数
+}
+
+func startDoQServer(t *testing.T) *doqServer {
+	t.Helper()
+	cert, pool := newTestCertificate(t)
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &doqServer{ln: ln, pool: pool}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.serveConn(conn)
+		}
+	}()
+	return s
+}
+
+func (s *doqServer) serveConn(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go s.serveStream(stream)
+	}
+}
+
+// serveStream 读取以 FIN 结束的查询，写入带长度前缀的响应
+func (s *doqServer) serveStream(stream *quic.Stream) {
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	if err != nil || len(data) < 2 {
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(data[2:]); err != nil || req.Id != 0 {
+		return
+	}
+
+	packed, err := txtReply(req, "quic").Pack()
+	if err != nil {
+		return
+	}
+	stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+}
+
+func (s *doqServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func TestProxyUpstreamQUICThroughSOCKS5(t *testing.T) {
+	server := startDoQServer(t)
+	socks := newTestSOCKS5(t, true)
+
+	u := newProxyUpstream(server.addr(), "quic", socks.outbound(t), nil, 5*time.Second)
+	u.tlsConfig.RootCAs = server.pool
+	defer u.Close()
+
+	// 并发的查询共享同一次握手
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion("example.com.", dns.TypeTXT)
+			if resp, err := u.Exchange(m); err == nil && resp.Id == m.Id {
+				results[i] = resp.Answer[0].(*dns.TXT).Txt[0]
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		if got != "quic" {
+			t.Fatalf("第 %d 条查询响应 %q，期望 quic", i+1, got)
+		}
+	}
+	if got := exchangeTXT(t, u); got != "quic" {
+		t.Fatalf("后续查询响应 %s，期望 quic", got)
+	}
+	if got := server.conns.Load(); got != 1 {
+		t.Errorf("建立了 %d 条 QUIC 连接，期望 1", got)
+	}
+	if got := socks.associations.Load(); got != 1 {
+		t.Errorf("建立了 %d 个 UDP 关联，期望 1", got)
+	}
+}
+
+func TestProxyUpstreamQUICCloseDuringHandshake(t *testing.T) {
+	// 目标不响应任何数据包，握手一直持续到超时
+	blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blackhole.Close()
+
+	socks := newTestSOCKS5(t, true)
+	u := newProxyUpstream(blackhole.LocalAddr().String(), "quic", socks.outbound(t), nil, time.Second)
+
+	errCh := make(chan error, 1)
+	go func() {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeTXT)
+		_, err := u.Exchange(m)
+		errCh <- err
+	}()
+
+	for deadline := time.Now().Add(time.Second); socks.associations.Load() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("没有建立 UDP 关联")
+		}
+	}
+
+	// 握手期间不持有锁，Close 立即返回
+	start := time.Now()
+	u.Close()
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Close 等待了 %v，握手期间不应持有锁", elapsed)
+	}
+
+	if err := <-errCh; err == nil {
+		t.Error("握手超时的查询应返回错误")
+	}
+	if u.quic != nil {
+		t.Error("upstream 关闭后不应保留 QUIC 连接")
+	}
+}
@@ -0,0 +1,411 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"violet-dns/outbound"
+
+	"github.com/miekg/dns"
+)
+
+// testSOCKS5 回环地址上的 SOCKS5 服务器，支持 CONNECT
+// udp 为 true 时支持 UDP ASSOCIATE（每个关联使用独立的中继端口转发到请求的目标），否则以 0x07（不支持的命令）拒绝
+type testSOCKS5 struct {
+	ln           net.Listener
+	udp          atomic.Bool
+	connects     atomic.Int32 // 成功建立的 CONNECT 连接数
+	associations atomic.Int32 // 成功建立的 UDP 关联数
+
+	mu     sync.Mutex
+	relays []io.Closer // 活跃关联的控制连接和中继
+}
+
+func newTestSOCKS5(t *testing.T, udp bool) *testSOCKS5 {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSOCKS5{ln: ln}
+	s.udp.Store(udp)
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropAssociations()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+// newConnectOnlySOCKS5 启动只支持 CONNECT 的 SOCKS5 服务器
+func newConnectOnlySOCKS5(t *testing.T) *outbound.SOCKS5Outbound {
+	return newTestSOCKS5(t, false).outbound(t)
+}
+
+func (s *testSOCKS5) outbound(t *testing.T) *outbound.SOCKS5Outbound {
+	t.Helper()
+	ob, err := outbound.NewSOCKS5Outbound("127.0.0.1", s.ln.Addr().(*net.TCPAddr).Port, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ob
+}
+
+// dropAssociations 关闭所有 UDP 关联（模拟代理回收空闲的中继）
+func (s *testSOCKS5) dropAssociations() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.relays {
+		c.Close()
+	}
+	s.relays = nil
+}
+
+func (s *testSOCKS5) handle(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{0x05, 0x00})
+
+	// VER CMD RSV ATYP
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	cmd := buf[1]
+
+	var host string
+	switch buf[3] {
+	case 0x01:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 0x03:
+		io.ReadFull(conn, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	default:
+		return
+	}
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	switch {
+	case cmd == 0x01:
+		s.connect(conn, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	case cmd == 0x03 && s.udp.Load():
+		s.associate(conn)
+	default:
+		conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+func (s *testSOCKS5) connect(conn net.Conn, address string) {
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	s.connects.Add(1)
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// associate 为关联分配中继端口，控制连接关闭时关闭中继
+func (s *testSOCKS5) associate(conn net.Conn) {
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer relay.Close()
+	s.associations.Add(1)
+	s.mu.Lock()
+	s.relays = append(s.relays, conn, relay)
+	s.mu.Unlock()
+
+	addr := relay.LocalAddr().(*net.UDPAddr)
+	reply := append([]byte{0x05, 0x00, 0x00, 0x01}, addr.IP.To4()...)
+	conn.Write(binary.BigEndian.AppendUint16(reply, uint16(addr.Port)))
+
+	go relayUDP(relay)
+	io.Copy(io.Discard, conn)
+}
+
+// relayUDP 转发中继数据包：第一个发送方视为客户端，去掉中继头后发往目标；其他来源的数据包加上中继头发回客户端
+func relayUDP(relay net.PacketConn) {
+	var client net.Addr
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if client == nil || from.String() == client.String() {
+			client = from
+			// RSV(2) FRAG(1) ATYP(IPv4) ADDR(4) PORT(2) DATA
+			if n < 10 || buf[2] != 0x00 || buf[3] != 0x01 {
+				continue
+			}
+			target := &net.UDPAddr{IP: net.IP(buf[4:8]), Port: int(binary.BigEndian.Uint16(buf[8:10]))}
+			relay.WriteTo(buf[10:n], target)
+			continue
+		}
+
+		src := from.(*net.UDPAddr)
+		packet := append([]byte{0x00, 0x00, 0x00, 0x01}, src.IP.To4()...)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(src.Port))
+		relay.WriteTo(append(packet, buf[:n]...), client)
+	}
+}
+
+// startDNSServers 在同一端口启动 UDP 和 TCP DNS 服务，TXT 记录为处理请求的协议
+func startDNSServers(t *testing.T) string {
+	t.Helper()
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{&dns.TXT{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: []string{w.LocalAddr().Network()},
+		}}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler}
+	tcpServer := &dns.Server{Listener: ln, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	t.Cleanup(func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	})
+
+	return pc.LocalAddr().String()
+}
+
+// exchangeTXT 查询 TXT 记录，返回处理请求的协议
+func exchangeTXT(t *testing.T, u *proxyUpstream) string {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeTXT)
+
+	resp, err := u.Exchange(m)
+	if err != nil {
+		t.Fatalf("查询失败: %v", err)
+	}
+	return resp.Answer[0].(*dns.TXT).Txt[0]
+}
+
+func TestProxyUpstreamUDPFallsBackToTCP(t *testing.T) {
+	address := startDNSServers(t)
+	socks := newTestSOCKS5(t, false)
+	u := newProxyUpstream(address, "udp", socks.outbound(t), nil, 5*time.Second)
+	defer u.Close()
+
+	for i := 0; i < 2; i++ {
+		if got := exchangeTXT(t, u); got != "tcp" {
+			t.Fatalf("第 %d 次查询使用了 %s，期望回退到 tcp", i+1, got)
+		}
+		if !u.udpRefusedRecently() {
+			t.Fatal("代理拒绝 UDP 后应记住并直接使用 TCP")
+		}
+	}
+
+	// 代理开始支持 UDP 后，重试间隔内仍使用 TCP，间隔过后重新尝试 UDP
+	socks.udp.Store(true)
+	if got := exchangeTXT(t, u); got != "tcp" {
+		t.Fatalf("重试间隔内使用了 %s，期望 tcp", got)
+	}
+	u.udpRefusedAt.Store(time.Now().Add(-udpRetryInterval).UnixNano())
+	if got := exchangeTXT(t, u); got != "udp" {
+		t.Fatalf("重试间隔过后使用了 %s，期望 udp", got)
+	}
+	if u.udpRefusedRecently() {
+		t.Error("UDP 中继建立成功后不应继续回退到 TCP")
+	}
+}
+
+func TestProxyUpstreamUDPReusesAssociation(t *testing.T) {
+	address := startDNSServers(t)
+	socks := newTestSOCKS5(t, true)
+	u := newProxyUpstream(address, "udp", socks.outbound(t), nil, 5*time.Second)
+	defer u.Close()
+
+	for i := 0; i < 3; i++ {
+		if got := exchangeTXT(t, u); got != "udp" {
+			t.Fatalf("第 %d 次查询使用了 %s，期望 udp", i+1, got)
+		}
+	}
+	if got := socks.associations.Load(); got != 1 {
+		t.Fatalf("建立了 %d 个 UDP 关联，期望 1（查询之间复用中继）", got)
+	}
+
+	// 代理回收中继后，重新建立关联重试一次
+	socks.dropAssociations()
+	if got := exchangeTXT(t, u); got != "udp" {
+		t.Fatalf("中继被回收后使用了 %s，期望 udp", got)
+	}
+	if got := socks.associations.Load(); got != 2 {
+		t.Errorf("建立了 %d 个 UDP 关联，期望 2", got)
+	}
+}
+
+// countingOutbound 统计建立连接次数的直连出站
+type countingOutbound struct {
+	*outbound.DirectOutbound
+	dials atomic.Int32
+}
+
+func (o *countingOutbound) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	o.dials.Add(1)
+	return o.DirectOutbound.Dial(ctx, network, address)
+}
+
+// dohServer HTTP/2 DoH 测试服务器
+// dropRequest 为 n 时，第 n 个请求不返回响应而是关闭所有客户端连接（模拟复用的连接已被服务器或代理断开）
+type dohServer struct {
+	*httptest.Server
+	requests    atomic.Int32
+	dropRequest int32
+}
+
+func newDoHServer(t testing.TB, dropRequest int32) *dohServer {
+	t.Helper()
+
+	s := &dohServer{dropRequest: dropRequest}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *dohServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.requests.Add(1) == s.dropRequest {
+		s.CloseClientConnections()
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{r.Proto},
+	}}
+	packed, _ := m.Pack()
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(packed)
+}
+
+// newTestHTTPSUpstream 创建连接 DoH 测试服务器的代理 upstream（信任测试服务器的证书）
+func newTestHTTPSUpstream(server *dohServer, ob outbound.Outbound) *proxyUpstream {
+	u := newProxyUpstream(server.URL+"/dns-query", "https", ob, nil, 5*time.Second)
+	u.client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return u
+}
+
+func TestProxyUpstreamHTTPSRetriesOnClosedConnection(t *testing.T) {
+	server := newDoHServer(t, 2)
+	ob := &countingOutbound{DirectOutbound: outbound.NewDirectOutbound()}
+	u := newTestHTTPSUpstream(server, ob)
+	defer u.Close()
+
+	for i := 0; i < 3; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeTXT)
+
+		resp, err := u.Exchange(m)
+		if err != nil {
+			t.Fatalf("第 %d 次查询失败: %v", i+1, err)
+		}
+		if got := resp.Answer[0].(*dns.TXT).Txt[0]; got != "HTTP/2.0" {
+			t.Fatalf("第 %d 次查询使用了 %s，期望 HTTP/2.0", i+1, got)
+		}
+	}
+
+	// 第 1 次查询建立连接；第 2 次查询的连接被断开，丢弃空闲连接后重连重试一次；第 3 次查询复用重建的连接
+	if got := server.requests.Load(); got != 4 {
+		t.Errorf("服务器收到 %d 个请求，期望 4（3 次查询 + 1 次重试）", got)
+	}
+	if got := ob.dials.Load(); got != 2 {
+		t.Errorf("建立了 %d 次连接，期望 2", got)
+	}
+}
+
+// BenchmarkProxyUpstreamHTTPS 对比复用连接与每次查询新建 Transport（原来的实现）的单次查询耗时
+func BenchmarkProxyUpstreamHTTPS(b *testing.B) {
+	server := newDoHServer(b, 0)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeTXT)
+
+	b.Run("pooled", func(b *testing.B) {
+		u := newTestHTTPSUpstream(server, outbound.NewDirectOutbound())
+		defer u.Close()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := u.Exchange(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per-query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			u := newTestHTTPSUpstream(server, outbound.NewDirectOutbound())
+			if _, err := u.Exchange(m); err != nil {
+				b.Fatal(err)
+			}
+			u.Close()
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DoT 连接和 UDP 中继的连接池参数
+const (
+	dotIdleConnTimeout = 30 * time.Second // 空闲 DoT 连接保留时间（服务器通常会更早关闭，失效连接在使用时重连）
+	udpIdleConnTimeout = 30 * time.Second // 空闲 UDP 中继保留时间（代理可能更早回收，失效中继在使用时重建）
+	maxIdleConns       = 4                // 每个上游每种连接保留的空闲连接数
+)
+
+// idleConn 空闲的 DoT 连接或 UDP 中继
+type idleConn struct {
+	conn     *dns.Conn
+	lastUsed time.Time
+}
+
+// newTLSConfig 创建上游 TLS 配置（校验服务器证书，SNI 为地址中的主机名）
+func newTLSConfig(address string, nextProtos ...string) *tls.Config {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	return &tls.Config{
+		ServerName:         host,
+		NextProtos:         nextProtos,
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+}
+
+// exchangeTLS 通过 DoT (DNS-over-TLS) 进行查询
+// 优先复用空闲连接，复用的连接失效时重新建立连接重试一次
+func (u *proxyUpstream) exchangeTLS(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if conn := u.takeIdleConn(&u.tlsIdle, dotIdleConnTimeout); conn != nil {
+		resp, err := exchangeConn(ctx, conn, m)
+		if err == nil {
+			u.releaseIdleConn(&u.tlsIdle, conn)
+			return resp, nil
+		}
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := u.dialTLS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := exchangeConn(ctx, conn, m)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	u.releaseIdleConn(&u.tlsIdle, conn)
+	return resp, nil
+}
+
+// dialTLS 经 outbound 建立 TCP 连接并完成 TLS 握手
+func (u *proxyUpstream) dialTLS(ctx context.Context) (*dns.Conn, error) {
+	raw, err := u.dial(ctx, u.address)
+	if err != nil {
+		return nil, fmt.Errorf("代理连接失败: %w", err)
+	}
+
+	conn := tls.Client(raw, u.tlsConfig)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("TLS 握手失败: %w", err)
+	}
+
+	return &dns.Conn{Conn: conn}, nil
+}
+
+// takeIdleConn 从空闲池取出最近使用的连接，顺带关闭空闲超过 timeout 的连接
+func (u *proxyUpstream) takeIdleConn(pool *[]*idleConn, timeout time.Duration) *dns.Conn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for len(*pool) > 0 {
+		last := (*pool)[len(*pool)-1]
+		*pool = (*pool)[:len(*pool)-1]
+		if time.Since(last.lastUsed) < timeout {
+			return last.conn
+		}
+		last.conn.Close()
+	}
+	return nil
+}
+
+// releaseIdleConn 将连接放回空闲池，池已满或 upstream 已关闭时关闭
+func (u *proxyUpstream) releaseIdleConn(pool *[]*idleConn, conn *dns.Conn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed || len(*pool) >= maxIdleConns {
+		conn.Close()
+		return
+	}
+	*pool = append(*pool, &idleConn{conn: conn, lastUsed: time.Now()})
+}
+
+// exchangeConn 在已建立的流式连接上完成一次查询
+func exchangeConn(ctx context.Context, conn *dns.Conn, m *dns.Msg) (*dns.Msg, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 发送查询
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, fmt.Errorf("发送 DNS 查询失败: %w", err)
+	}
+
+	// 接收响应
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		return nil, fmt.Errorf("读取 DNS 响应失败: %w", err)
+	}
+	if resp.Id != m.Id {
+		return nil, fmt.Errorf("DNS 响应 ID 不匹配: %d != %d", resp.Id, m.Id)
+	}
+
+	return resp, nil
+}
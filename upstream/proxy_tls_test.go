@@ -0,0 +1,103 @@
+package upstream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newTestCertificate 生成 127.0.0.1 的自签名证书，返回证书和信任它的 CertPool
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// txtReply 构造 TXT 记录为 txt 的响应
+func txtReply(r *dns.Msg, txt string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{txt},
+	}}
+	return m
+}
+
+// startDoTServer 在回环地址上启动 DoT 服务，TXT 记录为 "tls"；返回地址和信任其证书的 CertPool
+func startDoTServer(t *testing.T) (string, *x509.CertPool) {
+	t.Helper()
+	cert, pool := newTestCertificate(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(txtReply(r, "tls"))
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return ln.Addr().String(), pool
+}
+
+func TestProxyUpstreamTLSThroughSOCKS5(t *testing.T) {
+	address, pool := startDoTServer(t)
+	socks := newTestSOCKS5(t, false)
+
+	u := newProxyUpstream(address, "tls", socks.outbound(t), nil, 5*time.Second)
+	u.tlsConfig.RootCAs = pool
+	defer u.Close()
+
+	for i := 0; i < 3; i++ {
+		if got := exchangeTXT(t, u); got != "tls" {
+			t.Fatalf("第 %d 次查询响应 %s，期望 tls", i+1, got)
+		}
+	}
+	if got := socks.connects.Load(); got != 1 {
+		t.Errorf("经代理建立了 %d 条连接，期望 1（查询之间复用 DoT 连接）", got)
+	}
+
+	// 经代理建立连接后仍在本地校验服务器证书
+	untrusted := newProxyUpstream(address, "tls", socks.outbound(t), nil, 5*time.Second)
+	defer untrusted.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeTXT)
+	if _, err := untrusted.Exchange(m); err == nil {
+		t.Error("服务器证书不受信任时查询应失败")
+	}
+}
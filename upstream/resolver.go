@@ -0,0 +1,264 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// 解析结果缓存时间范围（按记录 TTL，限制在该范围内）
+const (
+	resolverMinTTL = 10 * time.Second
+	resolverMaxTTL = time.Hour
+)
+
+// 地址选择策略
+const (
+	StrategyIPv4Only   = "ipv4_only"   // 只使用 IPv4
+	StrategyIPv6Only   = "ipv6_only"   // 只使用 IPv6
+	StrategyPreferIPv4 = "prefer_ipv4" // IPv4 在前（默认）
+	StrategyPreferIPv6 = "prefer_ipv6" // IPv6 在前
+)
+
+// Resolver 解析上游 nameserver 中的域名（如 dns.google）
+// 直接向 bootstrap nameserver 查询（不经过出站代理），结果按记录 TTL 缓存；
+// 实现 AdGuard upstream 的 Bootstrap 接口
+type Resolver struct {
+	nameservers []resolverServer
+	strategy    string
+	timeout     time.Duration
+	cache       *resolverCache // 同一组 nameserver 的不同策略共享缓存
+}
+
+// resolverCache 解析结果缓存
+type resolverCache struct {
+	mu      sync.Mutex
+	entries map[string]*resolverEntry // key: 域名/记录类型
+	group   singleflight.Group
+}
+
+// resolverServer bootstrap nameserver
+type resolverServer struct {
+	network string // udp 或 tcp
+	address string // IP:端口
+}
+
+// resolverEntry 缓存的解析结果
+type resolverEntry struct {
+	addrs    []netip.Addr
+	expireAt time.Time
+}
+
+// NewResolver 创建域名解析器
+// nameservers 必须是 IP 地址，支持 "223.5.5.5"、"223.5.5.5:53"、"udp://223.5.5.5"、"tcp://223.5.5.5:53"
+func NewResolver(nameservers []string, timeout time.Duration) (*Resolver, error) {
+	r := &Resolver{
+		strategy: StrategyPreferIPv4,
+		timeout:  timeout,
+		cache:    &resolverCache{entries: make(map[string]*resolverEntry)},
+	}
+
+	for _, ns := range nameservers {
+		server, err := parseResolverServer(ns)
+		if err != nil {
+			return nil, err
+		}
+		r.nameservers = append(r.nameservers, server)
+	}
+	if len(r.nameservers) == 0 {
+		return nil, fmt.Errorf("至少需要一个 nameserver")
+	}
+
+	return r, nil
+}
+
+// WithStrategy 返回使用指定地址选择策略的解析器（与原解析器共享缓存）
+func (r *Resolver) WithStrategy(strategy string) *Resolver {
+	if strategy == "" {
+		strategy = StrategyPreferIPv4
+	}
+	copied := *r
+	copied.strategy = strategy
+	return &copied
+}
+
+// parseResolverServer 解析 bootstrap nameserver
+func parseResolverServer(nameserver string) (resolverServer, error) {
+	network, address := "udp", nameserver
+	if proto, rest, ok := strings.Cut(nameserver, "://"); ok {
+		if proto != "udp" && proto != "tcp" {
+			return resolverServer{}, fmt.Errorf("bootstrap nameserver 仅支持 udp 和 tcp: %s", nameserver)
+		}
+		network, address = proto, rest
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return resolverServer{}, fmt.Errorf("bootstrap nameserver 必须是 IP 地址: %s", nameserver)
+	}
+
+	return resolverServer{network: network, address: net.JoinHostPort(host, port)}, nil
+}
+
+// LookupNetIP 解析域名，network 为 ip4 (A)、ip6 (AAAA) 或 ip（按地址选择策略排序）
+func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, _, err := r.resolve(ctx, network, host)
+	return addrs, err
+}
+
+// resolve 解析域名，同时返回结果的过期时间（各记录类型中最早过期的时间）
+func (r *Resolver) resolve(ctx context.Context, network, host string) ([]netip.Addr, time.Time, error) {
+	host = strings.TrimSuffix(host, ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, time.Now().Add(resolverMaxTTL), nil
+	}
+
+	var qtypes []uint16
+	switch r.strategy {
+	case StrategyIPv4Only:
+		qtypes = []uint16{dns.TypeA}
+	case StrategyIPv6Only:
+		qtypes = []uint16{dns.TypeAAAA}
+	case StrategyPreferIPv6:
+		qtypes = []uint16{dns.TypeAAAA, dns.TypeA}
+	default:
+		qtypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	var addrs []netip.Addr
+	var expireAt time.Time
+	var lastErr error
+	for _, qtype := range qtypes {
+		if (network == "ip4" && qtype != dns.TypeA) || (network == "ip6" && qtype != dns.TypeAAAA) {
+			continue
+		}
+
+		entry, err := r.lookup(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, entry.addrs...)
+		if expireAt.IsZero() || entry.expireAt.Before(expireAt) {
+			expireAt = entry.expireAt
+		}
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, time.Time{}, lastErr
+		}
+		return nil, time.Time{}, fmt.Errorf("域名 %s 没有可用地址 (network=%s strategy=%s)", host, network, r.strategy)
+	}
+	return addrs, expireAt, nil
+}
+
+// lookup 查询单个记录类型，优先使用缓存，并发的相同查询只发送一次
+func (r *Resolver) lookup(ctx context.Context, host string, qtype uint16) (*resolverEntry, error) {
+	key := host + "/" + dns.TypeToString[qtype]
+
+	r.cache.mu.Lock()
+	entry, ok := r.cache.entries[key]
+	r.cache.mu.Unlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry, nil
+	}
+
+	v, err, _ := r.cache.group.Do(key, func() (interface{}, error) {
+		// 共享的查询不受首个调用者取消的影响，超时由 r.timeout 单独控制
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+		defer cancel()
+
+		addrs, ttl, err := r.query(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &resolverEntry{addrs: addrs, expireAt: time.Now().Add(ttl)}
+		r.cache.mu.Lock()
+		r.cache.entries[key] = entry
+		r.cache.mu.Unlock()
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*resolverEntry), nil
+}
+
+// query 依次向 bootstrap nameserver 查询，返回地址和缓存时间
+func (r *Resolver) query(ctx context.Context, host string, qtype uint16) ([]netip.Addr, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), qtype)
+
+	var lastErr error
+	for _, server := range r.nameservers {
+		resp, err := r.exchange(ctx, server, m)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s 返回 %s", server.address, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+
+		var addrs []netip.Addr
+		ttl := resolverMaxTTL
+		for _, rr := range resp.Answer {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+			if addr, ok := netip.AddrFromSlice(ip); ok {
+				addrs = append(addrs, addr.Unmap())
+			}
+			if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+		if len(addrs) == 0 {
+			ttl = negativeTTL(resp)
+		}
+		return addrs, max(ttl, resolverMinTTL), nil
+	}
+
+	return nil, 0, fmt.Errorf("解析 %s 失败: %w", host, lastErr)
+}
+
+// negativeTTL 根据授权部分的 SOA 计算 NXDOMAIN 和空应答的缓存时间（RFC 2308），没有 SOA 时为 resolverMinTTL
+func negativeTTL(resp *dns.Msg) time.Duration {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+			return min(ttl, resolverMaxTTL)
+		}
+	}
+	return resolverMinTTL
+}
+
+// exchange 向单个 bootstrap nameserver 发送查询，UDP 响应被截断时改用 TCP
+func (r *Resolver) exchange(ctx context.Context, server resolverServer, m *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: server.network, Timeout: r.timeout}
+	resp, _, err := client.ExchangeContext(ctx, m, server.address)
+	if err == nil && resp.Truncated && server.network == "udp" {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, m, server.address)
+	}
+	return resp, err
+}
@@ -0,0 +1,118 @@
+package upstream
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startResolverServer 启动 bootstrap 测试服务器：
+// nx.example. 返回带 SOA 的 NXDOMAIN，empty.example. 返回不带 SOA 的空应答，其他域名延迟 delay 后返回 192.0.2.1
+func startResolverServer(t *testing.T, delay time.Duration) (string, *atomic.Int32) {
+	t.Helper()
+
+	var queries atomic.Int32
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		name := r.Question[0].Name
+		switch name {
+		case "nx.example.":
+			m.Rcode = dns.RcodeNameError
+			m.Ns = []dns.RR{&dns.SOA{
+				Hdr:    dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+				Ns:     "ns.example.",
+				Mbox:   "admin.example.",
+				Minttl: 60,
+			}}
+		case "empty.example.":
+		default:
+			time.Sleep(delay)
+			if r.Question[0].Qtype == dns.TypeA {
+				m.Answer = []dns.RR{&dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 600},
+					A:   net.ParseIP("192.0.2.1"),
+				}}
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String(), &queries
+}
+
+func TestResolverNegativeTTL(t *testing.T) {
+	address, _ := startResolverServer(t, 0)
+	r, err := NewResolver([]string{address}, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		ttl  time.Duration
+	}{
+		{"nx.example", 60 * time.Second},  // SOA TTL 与 MINIMUM 中较小的值
+		{"empty.example", resolverMinTTL}, // 没有 SOA
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		entry, err := r.lookup(context.Background(), tt.host, dns.TypeA)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.host, err)
+		}
+		if len(entry.addrs) != 0 {
+			t.Fatalf("%s: addrs = %v, 期望为空", tt.host, entry.addrs)
+		}
+		if got := entry.expireAt.Sub(start); got < tt.ttl || got > tt.ttl+time.Second {
+			t.Errorf("%s: 缓存时间 = %v, 期望 %v", tt.host, got, tt.ttl)
+		}
+	}
+}
+
+func TestResolverSharedLookupIgnoresCallerDeadline(t *testing.T) {
+	address, queries := startResolverServer(t, 200*time.Millisecond)
+	r, err := NewResolver([]string{address}, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个调用者的超时短于服务器响应时间，共享同一查询的第二个调用者仍应得到结果
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.lookup(ctx, "dns.example", dns.TypeA)
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := r.lookup(context.Background(), "dns.example", dns.TypeA)
+		second <- err
+	}()
+
+	if err := <-second; err != nil {
+		t.Fatalf("第一个调用者超时后共享查询失败: %v", err)
+	}
+	<-first
+	if got := queries.Load(); got != 1 {
+		t.Errorf("发送了 %d 次查询，期望 1", got)
+	}
+}
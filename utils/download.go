@@ -27,6 +27,21 @@ func DownloadFileWithOutbound(url, destPath string, outbound Outbound) error {
 		os.Remove(destPath)
 	}
 
+	return FetchFileWithOutbound(url, destPath, outbound)
+}
+
+// FetchFileWithOutbound 通过指定的 outbound 下载文件，总是重新下载并原子替换已有文件
+func FetchFileWithOutbound(url, destPath string, outbound Outbound) error {
+	return fetchFile(url, destPath, outbound, 1024)
+}
+
+// FetchRuleSetWithOutbound 通过指定的 outbound 下载外部规则文件（规则文件可能很小，只要求非空）
+func FetchRuleSetWithOutbound(url, destPath string, outbound Outbound) error {
+	return fetchFile(url, destPath, outbound, 1)
+}
+
+// fetchFile 下载文件到临时文件，校验大小后原子替换目标文件
+func fetchFile(url, destPath string, outbound Outbound, minSize int64) error {
 	// 创建目录
 	dir := filepath.Dir(destPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -104,7 +119,7 @@ func DownloadFileWithOutbound(url, destPath string, outbound Outbound) error {
 	}
 
 	// 验证文件大小
-	if n < 1024 {
+	if n < minSize {
 		os.Remove(tmpFile)
 		return fmt.Errorf("下载的文件太小: %d 字节", n)
 	}